@@ -0,0 +1,204 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tusk-framework/tusk-engine/internal/config"
+)
+
+// Access log formats (the "access_log_format" key in tusk.json)
+const (
+	FormatCommon   = "common"
+	FormatCombined = "combined"
+	FormatJSON     = "json"
+)
+
+// Entry describes one served request
+type Entry struct {
+	Time       time.Time
+	RemoteAddr string
+	Method     string
+	URI        string
+	Proto      string
+	Status     int
+	Bytes      int64
+	Duration   time.Duration
+	Upstream   time.Duration // time spent waiting on the worker or FastCGI backend
+	RequestID  string
+	WorkerID   int // -1 when no worker was involved
+	Referer    string
+	UserAgent  string
+}
+
+// Logger writes access log entries in the configured format
+type Logger struct {
+	mu     sync.Mutex
+	out    io.WriteCloser
+	format string
+	sample float64
+	buf    []byte
+}
+
+// New creates the access logger described by cfg. It returns nil when
+// access logging is turned off.
+func New(cfg *config.Config) (*Logger, error) {
+	format := cfg.AccessLogFormat
+	switch format {
+	case "":
+		format = FormatCombined
+	case FormatCommon, FormatCombined, FormatJSON:
+	default:
+		return nil, fmt.Errorf("unknown access log format %q (expected %q, %q or %q)", format, FormatCommon, FormatCombined, FormatJSON)
+	}
+	if cfg.AccessLogSample < 0 || cfg.AccessLogSample > 1 {
+		return nil, fmt.Errorf("invalid access_log_sample %v: must be between 0 and 1", cfg.AccessLogSample)
+	}
+
+	var out io.WriteCloser
+	switch cfg.AccessLog {
+	case "", "off":
+		return nil, nil
+	case "stdout":
+		out = nopCloser{os.Stdout}
+	case "stderr":
+		out = nopCloser{os.Stderr}
+	default:
+		f, err := openRotating(cfg.AccessLog, int64(cfg.AccessLogMaxSizeMB)<<20, cfg.AccessLogMaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		out = f
+	}
+
+	return &Logger{out: out, format: format, sample: cfg.AccessLogSample}, nil
+}
+
+// Log writes an entry. With sampling enabled only that fraction of requests
+// is logged, but server errors (5xx) are always kept; a sample of 0 logs
+// nothing else.
+func (l *Logger) Log(e *Entry) {
+	if l.sample < 1 && e.Status < 500 && rand.Float64() >= l.sample {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.buf = l.buf[:0]
+	switch l.format {
+	case FormatJSON:
+		l.buf = appendJSON(l.buf, e)
+	case FormatCommon:
+		l.buf = appendCommon(l.buf, e)
+	default:
+		l.buf = appendCommon(l.buf, e)
+		l.buf = append(l.buf, ' ')
+		l.buf = appendQuoted(l.buf, e.Referer)
+		l.buf = append(l.buf, ' ')
+		l.buf = appendQuoted(l.buf, e.UserAgent)
+	}
+	l.buf = append(l.buf, '\n')
+	l.out.Write(l.buf)
+}
+
+// Close flushes and closes the log file
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.out.Close()
+}
+
+// appendCommon formats e in the Common Log Format:
+// host ident authuser [date] "request" status bytes
+func appendCommon(b []byte, e *Entry) []byte {
+	b = append(b, host(e.RemoteAddr)...)
+	b = append(b, " - - ["...)
+	b = e.Time.AppendFormat(b, "02/Jan/2006:15:04:05 -0700")
+	b = append(b, "] "...)
+	b = appendQuoted(b, e.Method+" "+e.URI+" "+e.Proto)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, int64(e.Status), 10)
+	b = append(b, ' ')
+	if e.Bytes > 0 {
+		b = strconv.AppendInt(b, e.Bytes, 10)
+	} else {
+		b = append(b, '-')
+	}
+	return b
+}
+
+// jsonEntry is the JSON lines representation of an Entry
+type jsonEntry struct {
+	Time       string  `json:"time"`
+	RemoteAddr string  `json:"remote_addr"`
+	Method     string  `json:"method"`
+	URI        string  `json:"uri"`
+	Proto      string  `json:"proto"`
+	Status     int     `json:"status"`
+	Bytes      int64   `json:"bytes"`
+	DurationMS float64 `json:"duration_ms"`
+	UpstreamMS float64 `json:"upstream_ms,omitempty"`
+	RequestID  string  `json:"request_id,omitempty"`
+	WorkerID   *int    `json:"worker_id,omitempty"`
+	Referer    string  `json:"referer,omitempty"`
+	UserAgent  string  `json:"user_agent,omitempty"`
+}
+
+func appendJSON(b []byte, e *Entry) []byte {
+	je := jsonEntry{
+		Time:       e.Time.Format(time.RFC3339Nano),
+		RemoteAddr: e.RemoteAddr,
+		Method:     e.Method,
+		URI:        e.URI,
+		Proto:      e.Proto,
+		Status:     e.Status,
+		Bytes:      e.Bytes,
+		DurationMS: milliseconds(e.Duration),
+		UpstreamMS: milliseconds(e.Upstream),
+		RequestID:  e.RequestID,
+		Referer:    e.Referer,
+		UserAgent:  e.UserAgent,
+	}
+	if e.WorkerID >= 0 {
+		je.WorkerID = &e.WorkerID
+	}
+	data, _ := json.Marshal(je)
+	return append(b, data...)
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// appendQuoted writes s as a quoted CLF field, "-" when empty
+func appendQuoted(b []byte, s string) []byte {
+	if s == "" {
+		return append(b, `"-"`...)
+	}
+	return strconv.AppendQuote(b, s)
+}
+
+// host strips the port from a remote address
+func host(addr string) string {
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		return h
+	}
+	if addr == "" {
+		return "-"
+	}
+	return addr
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,167 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tusk-framework/tusk-engine/internal/config"
+)
+
+type nopWriter struct {
+	bytes.Buffer
+}
+
+func (*nopWriter) Close() error { return nil }
+
+func testEntry() *Entry {
+	return &Entry{
+		Time:       time.Date(2024, 3, 5, 14, 7, 9, 0, time.FixedZone("", 3600)),
+		RemoteAddr: "203.0.113.7:51234",
+		Method:     "GET",
+		URI:        "/users?page=2",
+		Proto:      "HTTP/1.1",
+		Status:     200,
+		Bytes:      512,
+		Duration:   12500 * time.Microsecond,
+		Upstream:   10 * time.Millisecond,
+		RequestID:  "abc123",
+		WorkerID:   3,
+		Referer:    "https://example.com/",
+		UserAgent:  `curl/8.0 "quoted"`,
+	}
+}
+
+func TestFormats(t *testing.T) {
+	common := `203.0.113.7 - - [05/Mar/2024:14:07:09 +0100] "GET /users?page=2 HTTP/1.1" 200 512`
+	tests := []struct {
+		format, want string
+	}{
+		{FormatCommon, common + "\n"},
+		{FormatCombined, common + ` "https://example.com/" "curl/8.0 \"quoted\""` + "\n"},
+	}
+
+	for _, tt := range tests {
+		out := &nopWriter{}
+		l := &Logger{out: out, format: tt.format, sample: 1}
+		l.Log(testEntry())
+
+		if out.String() != tt.want {
+			t.Errorf("%s:\n got %q\nwant %q", tt.format, out.String(), tt.want)
+		}
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	out := &nopWriter{}
+	l := &Logger{out: out, format: FormatJSON, sample: 1}
+	l.Log(testEntry())
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("Invalid JSON line %q: %v", out.String(), err)
+	}
+	want := map[string]interface{}{
+		"remote_addr": "203.0.113.7:51234",
+		"status":      float64(200),
+		"bytes":       float64(512),
+		"duration_ms": 12.5,
+		"upstream_ms": float64(10),
+		"request_id":  "abc123",
+		"worker_id":   float64(3),
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s: got %v, want %v", k, got[k], v)
+		}
+	}
+
+	// Requests not served by a worker have no worker_id
+	out.Reset()
+	e := testEntry()
+	e.WorkerID = -1
+	l.Log(e)
+	if strings.Contains(out.String(), "worker_id") {
+		t.Errorf("Unexpected worker_id in %s", out.String())
+	}
+}
+
+func TestSampling(t *testing.T) {
+	for _, sample := range []float64{0, 0.000001} {
+		out := &nopWriter{}
+		l := &Logger{out: out, format: FormatCommon, sample: sample}
+
+		for i := 0; i < 100; i++ {
+			l.Log(testEntry())
+		}
+		e := testEntry()
+		e.Status = 502
+		l.Log(e)
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		if len(lines) != 1 || !strings.Contains(lines[0], " 502 ") {
+			t.Errorf("sample %v: expected only the server error to be logged, got %q", sample, out.String())
+		}
+	}
+}
+
+func TestInvalidSample(t *testing.T) {
+	for _, sample := range []float64{-1, 5} {
+		cfg := config.DefaultConfig()
+		cfg.AccessLogSample = sample
+		if _, err := New(cfg); err == nil {
+			t.Errorf("Expected an error for access_log_sample %v", sample)
+		}
+	}
+}
+
+func TestRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	cfg := config.DefaultConfig()
+	cfg.AccessLog = path
+	cfg.AccessLogFormat = FormatCommon
+	cfg.AccessLogMaxBackups = 2
+
+	l, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	// Rotate roughly every two lines
+	l.out.(*rotatingFile).maxSize = 200
+
+	for i := 0; i < 10; i++ {
+		l.Log(testEntry())
+	}
+	l.Close()
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatalf("Expected %s to exist: %v", name, err)
+		}
+		if info.Size() > 200 {
+			t.Errorf("%s is %d bytes, over the limit", name, info.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("Expected at most 2 backups")
+	}
+}
+
+func TestNewOff(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.AccessLog = "off"
+	if l, err := New(cfg); l != nil || err != nil {
+		t.Errorf("Expected no logger, got %v, %v", l, err)
+	}
+
+	cfg.AccessLog = "stdout"
+	cfg.AccessLogFormat = "apache"
+	if _, err := New(cfg); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
@@ -4,7 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/exec"
@@ -18,6 +18,8 @@ import (
 	"github.com/tusk-framework/tusk-engine/internal/config"
 	"github.com/tusk-framework/tusk-engine/internal/php"
 	"github.com/tusk-framework/tusk-engine/internal/server"
+	"github.com/tusk-framework/tusk-engine/internal/tracing"
+	"github.com/tusk-framework/tusk-engine/internal/watcher"
 	"github.com/tusk-framework/tusk-engine/internal/worker"
 )
 
@@ -37,7 +39,7 @@ func Run(args []string) {
 	switch command {
 	case "start", "dev":
 		// Both commands start tusk's high-performance server with worker pool
-		// "dev" is an alias for "start" to provide familiar npm/bun-style experience
+		// "dev" additionally watches the project and reloads workers on change
 		// Use tusk's server instead of php -S for stateful workers and better performance
 		// Check if a custom worker file is specified
 		// args[0] = binary name, args[1] = "start"/"dev", args[2] = optional worker file
@@ -45,27 +47,27 @@ func Run(args []string) {
 			workerFile := args[2]
 			// Validate the worker file exists
 			if _, err := os.Stat(workerFile); os.IsNotExist(err) {
-				log.Fatalf("Worker file not found: %s", workerFile)
+				fatal("Worker file not found", "file", workerFile)
 			}
 			// Validate it has a .php extension
 			if !strings.HasSuffix(strings.ToLower(workerFile), ".php") {
-				log.Fatalf("Worker file must be a PHP file (*.php): %s", workerFile)
+				fatal("Worker file must be a PHP file (*.php)", "file", workerFile)
 			}
 			cfg.WorkerCommand = workerFile
 		}
-		runServerWithConfig(cfg)
+		runServerWithConfig(cfg, command == "dev")
 	case "setup":
 		runSetup(cfg)
 	case "install":
 		runInstall(args[2:])
 	case "add":
 		if len(args) < 3 {
-			log.Fatalf("Usage: tusk add <package>")
+			fatal("Usage: tusk add <package>")
 		}
 		runAdd(args[2:])
 	case "remove":
 		if len(args) < 3 {
-			log.Fatalf("Usage: tusk remove <package>")
+			fatal("Usage: tusk remove <package>")
 		}
 		runRemove(args[2:])
 	case "update":
@@ -76,13 +78,13 @@ func Run(args []string) {
 		// Explicit command to run scripts from tusk.json or composer.json
 		// Usage: tusk run <script>
 		if len(args) < 3 {
-			log.Fatalf("Error: Script name required. Usage: tusk run <script>")
+			fatal("Script name required. Usage: tusk run <script>")
 		}
 		scriptName := args[2]
 		if script, ok := cfg.Scripts[scriptName]; ok {
 			runScript(script, args[3:])
 		} else {
-			log.Fatalf("Script '%s' not found in tusk.json or composer.json", scriptName)
+			fatal("Script not found in tusk.json or composer.json", "script", scriptName)
 		}
 	case "help":
 		printHelp()
@@ -101,7 +103,7 @@ func printHelp() {
 	fmt.Println("Tusk Native Engine (v0.1)")
 	fmt.Println("\nUsage:")
 	fmt.Println("  tusk start [worker-file]  Start the Application Server")
-	fmt.Println("  tusk dev [worker-file]    Start in development mode (alias for start)")
+	fmt.Println("  tusk dev [worker-file]    Start in development mode (reloads workers on change)")
 	fmt.Println("  tusk setup                Verify and setup environment")
 	fmt.Println("  tusk init                 Initialize a new tusk.json file")
 	fmt.Println("\nPackage Management:")
@@ -116,7 +118,7 @@ func printHelp() {
 	fmt.Println("  tusk [command]            Run a framework command")
 	fmt.Println("\nExamples:")
 	fmt.Println("  tusk start                # Start the high-performance tusk server")
-	fmt.Println("  tusk dev                  # Same as start - use tusk server, not php -S")
+	fmt.Println("  tusk dev                  # Like start, plus hot reload of PHP files")
 	fmt.Println("  tusk start custom.php     # Uses custom.php as worker")
 	fmt.Println("  tusk install              # Install dependencies from composer.json")
 	fmt.Println("  tusk add symfony/console  # Add a package")
@@ -143,28 +145,65 @@ func runSetup(cfg *config.Config) {
 	fmt.Println("\nTusk is ready to go!")
 }
 
-func runServerWithConfig(cfg *config.Config) {
-	// Resolve the worker path for logging
-	workerPath := cfg.WorkerCommand
-	if !filepath.IsAbs(workerPath) {
-		workerPath = filepath.Join(cfg.ProjectRoot, workerPath)
+func runServerWithConfig(cfg *config.Config, dev bool) {
+	if dev {
+		cfg.Debug = true
 	}
-	// Get absolute path for clearer logging
-	if absPath, err := filepath.Abs(workerPath); err == nil {
-		workerPath = absPath
+	if err := configureLogging(cfg); err != nil {
+		fatal("Invalid logging configuration", "err", err)
 	}
-	log.Printf("Starting server with worker: %s", workerPath)
 
-	// 2. Initialize Worker Pool
-	pool, err := worker.NewPool(cfg)
-	if err != nil {
-		log.Fatalf("Failed to initialize worker pool: %v", err)
+	if cfg.OTLPEndpoint != "" {
+		shutdown, err := tracing.Setup(context.Background(), cfg)
+		if err != nil {
+			fatal("Failed to set up tracing", "err", err)
+		}
+		defer shutdown(context.Background())
+		slog.Info("Exporting traces", "endpoint", cfg.OTLPEndpoint)
 	}
 
-	if err := pool.Start(); err != nil {
-		log.Fatalf("Failed to start worker pool: %v", err)
+	// Initialize Worker Pool (not needed when php-fpm does the work)
+	var pool *worker.Pool
+	if cfg.FastCGIAddress != "" {
+		slog.Info("Forwarding requests to FastCGI backend", "addr", cfg.FastCGIAddress)
+	} else {
+		// Resolve the worker path for logging
+		workerPath := cfg.WorkerCommand
+		if !filepath.IsAbs(workerPath) {
+			workerPath = filepath.Join(cfg.ProjectRoot, workerPath)
+		}
+		// Get absolute path for clearer logging
+		if absPath, err := filepath.Abs(workerPath); err == nil {
+			workerPath = absPath
+		}
+		slog.Info("Starting server", "worker", workerPath)
+
+		var err error
+		pool, err = worker.NewPool(cfg)
+		if err != nil {
+			fatal("Failed to initialize worker pool", "err", err)
+		}
+
+		if err := pool.Start(); err != nil {
+			fatal("Failed to start worker pool", "err", err)
+		}
+		defer pool.Stop()
+	}
+
+	// Hot reload in development mode
+	if dev && pool != nil {
+		w, err := watcher.New(cfg.ProjectRoot, cfg.WatchInclude, cfg.WatchExclude)
+		if err != nil {
+			fatal("Failed to watch project", "err", err)
+		}
+		defer w.Close()
+
+		slog.Info("Watching for changes", "root", cfg.ProjectRoot, "include", cfg.WatchInclude)
+		go w.Run(func(files []string) {
+			slog.Info("Files changed", "files", files)
+			pool.Reload()
+		})
 	}
-	defer pool.Stop()
 
 	// 3. Start HTTP Server
 	srv := server.NewServer(cfg, pool)
@@ -175,22 +214,64 @@ func runServerWithConfig(cfg *config.Config) {
 
 	go func() {
 		if err := srv.Start(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server failed: %v", err)
+			fatal("Server failed", "err", err)
 		}
 	}()
 
+	// SIGHUP rolls the workers onto freshly deployed code
+	if pool != nil {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+
+		go func() {
+			for range hup {
+				slog.Info("Received SIGHUP")
+				pool.Reload()
+			}
+		}()
+	}
+
 	<-stop
-	log.Println("Shutting down gracefully...")
+	slog.Info("Shutting down gracefully")
 
 	// Create a context with timeout for shutdown
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	if err := srv.Stop(ctx); err != nil {
-		log.Printf("Server forced to shutdown: %v", err)
+		slog.Warn("Server forced to shutdown", "err", err)
 	}
 
-	log.Println("Server stopped.")
+	slog.Info("Server stopped")
+}
+
+// configureLogging installs the engine's structured logger according to
+// log_level and log_format
+func configureLogging(cfg *config.Config) error {
+	var level slog.Level
+	if cfg.LogLevel != "" {
+		if err := level.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+			return err
+		}
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	switch cfg.LogFormat {
+	case "", "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, opts)))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, opts)))
+	default:
+		return fmt.Errorf("unknown log format %q (expected \"text\" or \"json\")", cfg.LogFormat)
+	}
+	return nil
+}
+
+// fatal logs an error with its attributes and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
 
 func runScript(script string, extraArgs []string) {
@@ -216,7 +297,7 @@ func runScript(script string, extraArgs []string) {
 		if exitError, ok := err.(*exec.ExitError); ok {
 			os.Exit(exitError.ExitCode())
 		}
-		log.Fatalf("Script failed: %v", err)
+		fatal("Script failed", "err", err)
 	}
 }
 
@@ -224,7 +305,7 @@ func proxyToPHPWithConfig(cfg *config.Config, args []string) {
 	// Initialize PHP Manager to find the binary
 	mgr, err := php.NewManager(cfg.PhpBinary)
 	if err != nil {
-		log.Fatalf("Error resolving PHP: %v", err)
+		fatal("Error resolving PHP", "err", err)
 	}
 
 	// Target script: user's "tusk" script or "console"
@@ -233,7 +314,7 @@ func proxyToPHPWithConfig(cfg *config.Config, args []string) {
 		if _, err := os.Stat("console"); err == nil {
 			script = "console"
 		} else {
-			log.Fatalf("Could not find 'tusk' or 'console' script to execute.")
+			fatal("Could not find 'tusk' or 'console' script to execute")
 		}
 	}
 
@@ -249,7 +330,7 @@ func proxyToPHPWithConfig(cfg *config.Config, args []string) {
 		if exitError, ok := err.(*exec.ExitError); ok {
 			os.Exit(exitError.ExitCode())
 		}
-		log.Fatalf("Execution failed: %v", err)
+		fatal("Execution failed", "err", err)
 	}
 }
 
@@ -276,11 +357,11 @@ func runInit() {
 	// Write tusk.json
 	data, err := json.MarshalIndent(cfg, "", "    ")
 	if err != nil {
-		log.Fatalf("Failed to create tusk.json: %v", err)
+		fatal("Failed to create tusk.json", "err", err)
 	}
 
 	if err := os.WriteFile("tusk.json", data, 0644); err != nil {
-		log.Fatalf("Failed to write tusk.json: %v", err)
+		fatal("Failed to write tusk.json", "err", err)
 	}
 
 	fmt.Println("Created tusk.json successfully!")
@@ -292,7 +373,7 @@ func runInstall(args []string) {
 
 	// Check if composer is installed
 	if _, err := exec.LookPath("composer"); err != nil {
-		log.Fatalf("Composer not found. Please install composer: https://getcomposer.org/")
+		fatal("Composer not found. Please install composer: https://getcomposer.org/")
 	}
 
 	// Run composer install
@@ -303,7 +384,7 @@ func runInstall(args []string) {
 	cmd.Stderr = os.Stderr
 
 	if err := cmd.Run(); err != nil {
-		log.Fatalf("Failed to install dependencies: %v", err)
+		fatal("Failed to install dependencies", "err", err)
 	}
 
 	fmt.Println("Dependencies installed successfully!")
@@ -315,7 +396,7 @@ func runAdd(packages []string) {
 
 	// Check if composer is installed
 	if _, err := exec.LookPath("composer"); err != nil {
-		log.Fatalf("Composer not found. Please install composer: https://getcomposer.org/")
+		fatal("Composer not found. Please install composer: https://getcomposer.org/")
 	}
 
 	// Run composer require
@@ -326,7 +407,7 @@ func runAdd(packages []string) {
 	cmd.Stderr = os.Stderr
 
 	if err := cmd.Run(); err != nil {
-		log.Fatalf("Failed to add package: %v", err)
+		fatal("Failed to add package", "err", err)
 	}
 
 	fmt.Println("Package(s) added successfully!")
@@ -338,7 +419,7 @@ func runRemove(packages []string) {
 
 	// Check if composer is installed
 	if _, err := exec.LookPath("composer"); err != nil {
-		log.Fatalf("Composer not found. Please install composer: https://getcomposer.org/")
+		fatal("Composer not found. Please install composer: https://getcomposer.org/")
 	}
 
 	// Run composer remove
@@ -349,7 +430,7 @@ func runRemove(packages []string) {
 	cmd.Stderr = os.Stderr
 
 	if err := cmd.Run(); err != nil {
-		log.Fatalf("Failed to remove package: %v", err)
+		fatal("Failed to remove package", "err", err)
 	}
 
 	fmt.Println("Package(s) removed successfully!")
@@ -365,7 +446,7 @@ func runUpdate(packages []string) {
 
 	// Check if composer is installed
 	if _, err := exec.LookPath("composer"); err != nil {
-		log.Fatalf("Composer not found. Please install composer: https://getcomposer.org/")
+		fatal("Composer not found. Please install composer: https://getcomposer.org/")
 	}
 
 	// Run composer update
@@ -376,7 +457,7 @@ func runUpdate(packages []string) {
 	cmd.Stderr = os.Stderr
 
 	if err := cmd.Run(); err != nil {
-		log.Fatalf("Failed to update dependencies: %v", err)
+		fatal("Failed to update dependencies", "err", err)
 	}
 
 	fmt.Println("Dependencies updated successfully!")
@@ -3,8 +3,10 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"os"
 	"strings"
+	"time"
 )
 
 // Config holds the Tusk Engine configuration
@@ -21,6 +23,139 @@ type Config struct {
 	ProjectRoot   string            `json:"project_root"`
 	Scripts       map[string]string `json:"scripts"`
 
+	// Worker protocol: "ndjson" (default) or "msgpack" (length-prefixed)
+	Protocol string `json:"protocol"`
+	// Streaming sends request bodies as chunk frames and lets workers
+	// stream their responses back
+	Streaming bool `json:"streaming"`
+	// WorkerHandshake makes the engine wait for each worker's "ready"
+	// message before sending it requests; BootTimeout bounds the wait
+	WorkerHandshake bool     `json:"worker_handshake"`
+	BootTimeout     Duration `json:"boot_timeout"`
+	// RequestTimeout is how long a worker may take to start answering
+	// before it is killed and the client gets a 504 (0 disables it)
+	RequestTimeout Duration `json:"request_timeout"`
+	// CancelGrace is how long a worker may take to stop (or, without the
+	// cancel capability, finish) a request whose client went away before
+	// it is killed and replaced
+	CancelGrace Duration `json:"cancel_grace"`
+	// Load shedding: requests beyond MaxQueueLength waiting ones, or that
+	// waited MaxQueueWait for a worker, get a 503 (0 disables a limit).
+	// Requests whose path matches PriorityPaths (globs) jump the queue.
+	MaxQueueLength int      `json:"max_queue_length"`
+	MaxQueueWait   Duration `json:"max_queue_wait"`
+	PriorityPaths  []string `json:"priority_paths"`
+	// MaxRetries is how often a GET, HEAD or OPTIONS request (or one with
+	// an Idempotency-Key header) is retried on another worker when its
+	// worker dies before answering (0 disables retries)
+	MaxRetries int `json:"max_retries"`
+
+	// Worker recycling: a worker is replaced after finishing the request
+	// that crosses any of these limits (0 disables a limit)
+	MaxRequests int      `json:"max_requests"`
+	MaxLifetime Duration `json:"max_lifetime"`
+	MaxMemoryMB int      `json:"max_memory_mb"`
+
+	// Dynamic pool sizing (like php-fpm's pm = dynamic). Enabled when
+	// MaxWorkers is set; WorkerCount is then ignored.
+	MinWorkers       int      `json:"min_workers"`
+	MaxWorkers       int      `json:"max_workers"`
+	IdleTimeout      Duration `json:"idle_timeout"`
+	ScaleUpThreshold Duration `json:"scale_up_threshold"`
+
+	// Crashed workers are restarted after an exponential backoff starting
+	// at RestartBackoff and capped at RestartBackoffMax. CrashLoopThreshold
+	// crashes within CrashLoopWindow mark the pool unhealthy; requests then
+	// get a 503 until crashes stop (0 disables the detector).
+	RestartBackoff     Duration `json:"restart_backoff"`
+	RestartBackoffMax  Duration `json:"restart_backoff_max"`
+	CrashLoopThreshold int      `json:"crash_loop_threshold"`
+	CrashLoopWindow    Duration `json:"crash_loop_window"`
+
+	// Health checks: every HealthCheckInterval idle workers are sent a
+	// ping frame and replaced unless they answer within HealthCheckTimeout
+	// (0 disables pings). /readyz needs ReadyMinWorkers healthy workers.
+	HealthCheckInterval Duration `json:"health_check_interval"`
+	HealthCheckTimeout  Duration `json:"health_check_timeout"`
+	ReadyMinWorkers     int      `json:"ready_min_workers"`
+
+	// Files watched by "tusk dev" to hot reload workers
+	WatchInclude []string `json:"watch_include"`
+	WatchExclude []string `json:"watch_exclude"`
+
+	// Static files under PublicDir (relative to ProjectRoot) are served
+	// directly instead of going through a worker
+	PublicDir          string `json:"public_dir"`
+	StaticCacheControl string `json:"static_cache_control"`
+
+	// TLS termination. TLSCert/TLSKey is the default certificate and
+	// TLSCertificates adds more, picked by SNI. Files are reloaded when
+	// they change on disk.
+	TLSCert         string           `json:"tls_cert"`
+	TLSKey          string           `json:"tls_key"`
+	TLSCertificates []TLSCertificate `json:"tls_certificates,omitempty"`
+	// HTTP2 enables HTTP/2 over TLS (negotiated with ALPN); H2C enables
+	// cleartext HTTP/2, e.g. behind a load balancer that speaks h2c
+	HTTP2 bool `json:"http2"`
+	H2C   bool `json:"h2c"`
+	// HTTPRedirectPort starts a plain HTTP listener that redirects to HTTPS
+	HTTPRedirectPort int `json:"http_redirect_port"`
+
+	// WebSocketRoutes lists the paths (globs such as "/chat/*") where
+	// WebSocket upgrades are accepted; events are dispatched to workers
+	WebSocketRoutes []string `json:"websocket_routes,omitempty"`
+
+	// WebSocketMaxMessageSize is the largest message in bytes read from a
+	// WebSocket client; larger ones close the connection with 1009
+	WebSocketMaxMessageSize int64 `json:"websocket_max_message_size"`
+
+	// Logging. LogLevel is one of "debug", "info", "warn" or "error" and
+	// LogFormat is "text" or "json".
+	LogLevel  string `json:"log_level"`
+	LogFormat string `json:"log_format"`
+
+	// Access log: "stdout", "stderr", "off" or a file path. Files are
+	// rotated once they reach AccessLogMaxSizeMB. AccessLogSample logs only
+	// that fraction of requests (server errors are always logged, so 0
+	// logs only those).
+	AccessLog           string  `json:"access_log"`
+	AccessLogFormat     string  `json:"access_log_format"`
+	AccessLogSample     float64 `json:"access_log_sample"`
+	AccessLogMaxSizeMB  int     `json:"access_log_max_size_mb"`
+	AccessLogMaxBackups int     `json:"access_log_max_backups"`
+
+	// RequestIDHeader carries the request ID; a valid incoming value is
+	// kept, otherwise one is generated
+	RequestIDHeader string `json:"request_id_header"`
+
+	// OpenTelemetry tracing: spans are exported over OTLP/HTTP when
+	// OTLPEndpoint is set (e.g. "http://localhost:4318")
+	OTLPEndpoint     string  `json:"otlp_endpoint"`
+	TraceServiceName string  `json:"trace_service_name"`
+	TraceSampleRatio float64 `json:"trace_sample_ratio"`
+
+	// Debug shows diagnostics such as worker stderr on error pages; "tusk
+	// dev" turns it on
+	Debug bool `json:"debug"`
+
+	// AdminToken protects the admin API; requests must send it as
+	// "Authorization: Bearer <token>". Without AdminAddress it enables
+	// POST /_tusk/reload on the application port.
+	AdminToken string `json:"admin_token"`
+
+	// AdminAddress ("host:port" or "unix:/path") serves metrics, health,
+	// pprof, pool status and control actions on a separate listener
+	// instead of the application port
+	AdminAddress string `json:"admin_address"`
+
+	// FastCGI upstream configuration. When FastCGIAddress is set, requests
+	// are forwarded to a FastCGI backend (e.g. php-fpm) instead of the
+	// NDJSON worker pool. The address is "host:port", "unix:/path/to.sock"
+	// or a plain socket path.
+	FastCGIAddress   string `json:"fastcgi_address"`
+	FastCGIScript    string `json:"fastcgi_script"`
+	FastCGIMultiplex bool   `json:"fastcgi_multiplex"`
+
 	// Package management (from composer.json)
 	Name             string                       `json:"name,omitempty"`
 	Description      string                       `json:"description,omitempty"`
@@ -46,6 +181,12 @@ type Config struct {
 	Repositories     []interface{}                `json:"repositories,omitempty"`
 }
 
+// TLSCertificate is a certificate/key pair in PEM files
+type TLSCertificate struct {
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+}
+
 // Author represents a package author
 type Author struct {
 	Name     string `json:"name"`
@@ -92,6 +233,45 @@ func DefaultConfig() *Config {
 		PhpIni:        "", // Empty means use system default
 		ProjectRoot:   "./",
 		Scripts:       make(map[string]string),
+
+		Protocol:       "ndjson",
+		RequestTimeout: Duration(60 * time.Second),
+		BootTimeout:    Duration(30 * time.Second),
+		MaxRetries:     1,
+		CancelGrace:    Duration(5 * time.Second),
+
+		IdleTimeout:      Duration(10 * time.Second),
+		ScaleUpThreshold: Duration(100 * time.Millisecond),
+
+		RestartBackoff:     Duration(1 * time.Second),
+		RestartBackoffMax:  Duration(30 * time.Second),
+		CrashLoopThreshold: 5,
+		CrashLoopWindow:    Duration(60 * time.Second),
+
+		HealthCheckTimeout: Duration(5 * time.Second),
+		ReadyMinWorkers:    1,
+
+		WatchInclude: []string{"*.php"},
+		WatchExclude: []string{"vendor/", "node_modules/"},
+
+		StaticCacheControl: "public, max-age=3600",
+		HTTP2:              true,
+
+		WebSocketMaxMessageSize: 1024 * 1024,
+
+		RequestIDHeader:     "X-Request-ID",
+		LogLevel:            "info",
+		LogFormat:           "text",
+		AccessLog:           "stdout",
+		AccessLogFormat:     "combined",
+		AccessLogSample:     1,
+		AccessLogMaxSizeMB:  100,
+		AccessLogMaxBackups: 5,
+
+		TraceServiceName: "tusk",
+		TraceSampleRatio: 1,
+
+		FastCGIScript: "index.php",
 	}
 }
 
@@ -114,7 +294,7 @@ func LoadConfig() *Config {
 
 	decoder := json.NewDecoder(file)
 	if err := decoder.Decode(cfg); err != nil {
-		fmt.Printf("Warning: Failed to parse tusk.json: %v. Using defaults.\n", err)
+		slog.Warn("Failed to parse tusk.json, using defaults", "err", err)
 		return cfg
 	}
 
@@ -132,7 +312,7 @@ func loadComposerConfig(cfg *Config) {
 	var composer ComposerConfig
 	decoder := json.NewDecoder(file)
 	if err := decoder.Decode(&composer); err != nil {
-		fmt.Printf("Warning: Failed to parse composer.json: %v\n", err)
+		slog.Warn("Failed to parse composer.json", "err", err)
 		return
 	}
 
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDurationUnmarshal(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{`"30s"`, 30 * time.Second},
+		{`"1m30s"`, 90 * time.Second},
+		{`5`, 5 * time.Second},
+		{`0.5`, 500 * time.Millisecond},
+		{`null`, 0},
+	}
+	for _, tt := range tests {
+		var d Duration
+		if err := json.Unmarshal([]byte(tt.in), &d); err != nil {
+			t.Errorf("Unmarshal(%s) failed: %v", tt.in, err)
+			continue
+		}
+		if time.Duration(d) != tt.want {
+			t.Errorf("Unmarshal(%s) = %v, want %v", tt.in, time.Duration(d), tt.want)
+		}
+	}
+
+	var d Duration
+	if err := json.Unmarshal([]byte(`"soon"`), &d); err == nil {
+		t.Error("Expected error for invalid duration")
+	}
+}
+
+func TestDurationMarshal(t *testing.T) {
+	data, err := json.Marshal(Duration(90 * time.Second))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if string(data) != `"1m30s"` {
+		t.Errorf("Marshal = %s, want \"1m30s\"", data)
+	}
+}
@@ -0,0 +1,404 @@
+package ipc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// FastCGI protocol constants (see the FastCGI 1.0 specification)
+const (
+	fcgiVersion1 = 1
+
+	typeBeginRequest = 1
+	typeAbortRequest = 2
+	typeEndRequest   = 3
+	typeParams       = 4
+	typeStdin        = 5
+	typeStdout       = 6
+	typeStderr       = 7
+
+	roleResponder = 1
+	flagKeepConn  = 1
+
+	statusRequestComplete = 0
+	statusCantMultiplex   = 1
+	statusOverloaded      = 2
+	statusUnknownRole     = 3
+
+	headerLen  = 8
+	maxContent = 65535
+)
+
+// Response data buffered per request. On a connection of its own, the read
+// loop waits for the consumer once stdoutLimit bytes are buffered. On a
+// multiplexed connection waiting would stall every other request, so it
+// buffers up to multiplexLimit bytes and then fails the request with
+// errSlowConsumer.
+const (
+	stdoutLimit    = 1024 * 1024
+	multiplexLimit = 16 * 1024 * 1024
+)
+
+var errSlowConsumer = errors.New("fastcgi: response consumer too slow")
+
+// header is the fixed 8-byte prefix of every FastCGI record
+type header struct {
+	Version       uint8
+	Type          uint8
+	ID            uint16
+	ContentLength uint16
+	PaddingLength uint8
+	Reserved      uint8
+}
+
+// request tracks a single in-flight FastCGI request on a connection. Only
+// the read loop updates it, and it closes done once.
+type request struct {
+	id     uint16
+	stdout *stdoutBuffer
+	stderr []byte
+
+	// Set by the read loop before done is closed
+	appStatus      uint32
+	protocolStatus uint8
+	err            error
+	done           chan struct{}
+}
+
+// conn is a single connection to the FastCGI backend. Requests on the same
+// connection are demultiplexed by a dedicated read loop.
+type conn struct {
+	nc        net.Conn
+	multiplex bool
+	wmu       sync.Mutex // serializes record writes
+
+	mu     sync.Mutex
+	reqs   map[uint16]*request
+	nextID uint16
+	err    error
+
+	buf [headerLen + maxContent + 255]byte
+}
+
+func newConn(nc net.Conn, multiplex bool) *conn {
+	c := &conn{
+		nc:        nc,
+		multiplex: multiplex,
+		reqs:      make(map[uint16]*request),
+	}
+	go c.readLoop()
+	return c
+}
+
+// register allocates a request ID on the connection
+func (c *conn) register() (*request, *stdoutBuffer, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return nil, nil, c.err
+	}
+
+	for {
+		c.nextID++
+		if c.nextID == 0 {
+			continue
+		}
+		if _, inUse := c.reqs[c.nextID]; !inUse {
+			break
+		}
+	}
+
+	req := &request{
+		id:     c.nextID,
+		stdout: newStdoutBuffer(!c.multiplex),
+		done:   make(chan struct{}),
+	}
+	c.reqs[req.id] = req
+	return req, req.stdout, nil
+}
+
+// inFlight returns the number of requests currently using the connection
+func (c *conn) inFlight() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.reqs)
+}
+
+// broken reports whether the connection can no longer be used
+func (c *conn) broken() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err != nil
+}
+
+// close tears down the connection. The read loop then fails every
+// in-flight request.
+func (c *conn) close(err error) {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.mu.Unlock()
+
+	c.nc.Close()
+}
+
+// drop forgets a request that is given up on and fails it with err. Records
+// the backend still sends for it are dropped by the read loop.
+func (c *conn) drop(req *request, err error) {
+	c.mu.Lock()
+	owned := c.reqs[req.id] == req
+	if owned {
+		delete(c.reqs, req.id)
+	}
+	c.mu.Unlock()
+
+	if owned {
+		req.finish(err)
+	}
+}
+
+// readLoop routes records to their requests until the connection fails,
+// and then fails the requests still in flight
+func (c *conn) readLoop() {
+	c.close(c.readRecords())
+
+	c.mu.Lock()
+	err := c.err
+	reqs := c.reqs
+	c.reqs = make(map[uint16]*request)
+	c.mu.Unlock()
+
+	for _, req := range reqs {
+		req.finish(err)
+	}
+}
+
+// readRecords reads records from the backend and routes them to their
+// requests, returning why it stopped
+func (c *conn) readRecords() error {
+	br := bufio.NewReader(c.nc)
+	content := make([]byte, maxContent+255)
+
+	for {
+		var h header
+		if err := binary.Read(br, binary.BigEndian, &h); err != nil {
+			return fmt.Errorf("fastcgi: read header: %w", err)
+		}
+		if h.Version != fcgiVersion1 {
+			return fmt.Errorf("fastcgi: unsupported protocol version %d", h.Version)
+		}
+
+		n := int(h.ContentLength) + int(h.PaddingLength)
+		if _, err := io.ReadFull(br, content[:n]); err != nil {
+			return fmt.Errorf("fastcgi: read content: %w", err)
+		}
+		body := content[:h.ContentLength]
+
+		c.mu.Lock()
+		req, ok := c.reqs[h.ID]
+		if ok && h.Type == typeEndRequest {
+			delete(c.reqs, h.ID)
+		}
+		c.mu.Unlock()
+		if !ok {
+			// Records for aborted or unknown requests are dropped
+			continue
+		}
+
+		switch h.Type {
+		case typeStdout:
+			if len(body) > 0 {
+				// A consumer that went away makes the write fail fast,
+				// so the loop keeps draining
+				if err := req.stdout.write(body); errors.Is(err, errSlowConsumer) {
+					req.stdout.fail(err)
+				}
+			}
+		case typeStderr:
+			req.stderr = append(req.stderr, body...)
+		case typeEndRequest:
+			if len(body) < 8 {
+				err := errors.New("fastcgi: short end request record")
+				req.finish(err)
+				return err
+			}
+			req.appStatus = binary.BigEndian.Uint32(body[0:4])
+			req.protocolStatus = body[4]
+			req.finish(nil)
+		}
+	}
+}
+
+// finish completes the request, successfully if err is nil
+func (r *request) finish(err error) {
+	r.err = err
+	r.stdout.finish(err)
+	close(r.done)
+}
+
+// stdoutBuffer carries a request's FCGI_STDOUT from the read loop to the
+// consumer. Unlike io.Pipe, the read loop only waits for the consumer once
+// stdoutLimit bytes are buffered, and never on multiplexed connections.
+type stdoutBuffer struct {
+	mu     sync.Mutex
+	cond   sync.Cond
+	data   []byte
+	err    error // returned once data is drained: io.EOF or the failure
+	closed bool  // the consumer went away
+	block  bool  // wait for the consumer when full, instead of failing
+}
+
+func newStdoutBuffer(block bool) *stdoutBuffer {
+	b := &stdoutBuffer{block: block}
+	b.cond.L = &b.mu
+	return b
+}
+
+// write appends response data. It fails once the stream is finished or the
+// consumer went away, and with errSlowConsumer if the buffer is full and
+// may not block.
+func (b *stdoutBuffer) write(p []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for b.block && len(b.data) >= stdoutLimit && b.err == nil && !b.closed {
+		b.cond.Wait()
+	}
+	if b.err != nil || b.closed {
+		return io.ErrClosedPipe
+	}
+	if len(b.data)+len(p) > multiplexLimit && !b.block {
+		return errSlowConsumer
+	}
+	b.data = append(b.data, p...)
+	b.cond.Broadcast()
+	return nil
+}
+
+// finish ends the stream once the buffered data is read; err nil means the
+// response is complete
+func (b *stdoutBuffer) finish(err error) {
+	if err == nil {
+		err = io.EOF
+	}
+	b.mu.Lock()
+	if b.err == nil {
+		b.err = err
+	}
+	b.cond.Broadcast()
+	b.mu.Unlock()
+}
+
+// fail ends the stream right away, discarding buffered data
+func (b *stdoutBuffer) fail(err error) {
+	b.mu.Lock()
+	b.data = nil
+	b.mu.Unlock()
+	b.finish(err)
+}
+
+func (b *stdoutBuffer) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for len(b.data) == 0 && b.err == nil && !b.closed {
+		b.cond.Wait()
+	}
+	if b.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if len(b.data) == 0 {
+		return 0, b.err
+	}
+	n := copy(p, b.data)
+	b.data = b.data[n:]
+	b.cond.Broadcast()
+	return n, nil
+}
+
+// Close is called by the consumer; later writes fail fast
+func (b *stdoutBuffer) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	b.data = nil
+	b.cond.Broadcast()
+	b.mu.Unlock()
+	return nil
+}
+
+// writeRecord writes a single record; content must not exceed maxContent
+func (c *conn) writeRecord(typ uint8, id uint16, content []byte) error {
+	padding := uint8(-len(content) & 7)
+	h := header{
+		Version:       fcgiVersion1,
+		Type:          typ,
+		ID:            id,
+		ContentLength: uint16(len(content)),
+		PaddingLength: padding,
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	buf := c.buf[:0]
+	buf = append(buf, h.Version, h.Type)
+	buf = binary.BigEndian.AppendUint16(buf, h.ID)
+	buf = binary.BigEndian.AppendUint16(buf, h.ContentLength)
+	buf = append(buf, h.PaddingLength, h.Reserved)
+	buf = append(buf, content...)
+	buf = append(buf, make([]byte, padding)...)
+
+	_, err := c.nc.Write(buf)
+	return err
+}
+
+// writeStream writes data as a sequence of records of the given type
+func (c *conn) writeStream(typ uint8, id uint16, data []byte) error {
+	for len(data) > 0 {
+		n := len(data)
+		if n > maxContent {
+			n = maxContent
+		}
+		if err := c.writeRecord(typ, id, data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// writeBeginRequest starts a responder request that keeps the connection open
+func (c *conn) writeBeginRequest(id uint16) error {
+	b := [8]byte{0, roleResponder, flagKeepConn}
+	return c.writeRecord(typeBeginRequest, id, b[:])
+}
+
+// writeParams sends the CGI environment followed by the empty terminator
+func (c *conn) writeParams(id uint16, params map[string]string) error {
+	var buf []byte
+	for k, v := range params {
+		buf = appendLength(buf, len(k))
+		buf = appendLength(buf, len(v))
+		buf = append(buf, k...)
+		buf = append(buf, v...)
+	}
+	if err := c.writeStream(typeParams, id, buf); err != nil {
+		return err
+	}
+	return c.writeRecord(typeParams, id, nil)
+}
+
+// appendLength encodes a name-value pair length (1 or 4 bytes)
+func appendLength(buf []byte, n int) []byte {
+	if n < 128 {
+		return append(buf, byte(n))
+	}
+	return binary.BigEndian.AppendUint32(buf, uint32(n)|1<<31)
+}
@@ -1,21 +1,414 @@
 package ipc
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/textproto"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tusk-framework/tusk-engine/internal/config"
 )
 
-// Client handles communication with PHP workers
+// ErrResponseStarted wraps errors that happen after the response header has
+// already been sent to the client, when an error page can no longer be shown.
+var ErrResponseStarted = errors.New("fastcgi: response already started")
+
+// maxIdleConns is the number of idle connections kept open to the backend
+const maxIdleConns = 8
+
+// Client forwards HTTP requests to a FastCGI backend such as php-fpm
 type Client struct {
-	// connection details
+	network        string
+	address        string
+	documentRoot   string
+	scriptFilename string
+	scriptName     string
+	multiplex      bool
+	dialTimeout    time.Duration
+
+	mu      sync.Mutex
+	conns   []*conn
+	dialing chan struct{} // closed once a connection is dialed, see acquire
 }
 
-// NewClient creates a new IPC client
-func NewClient() *Client {
-	return &Client{}
+// NewClient creates a new FastCGI client from the engine configuration
+func NewClient(cfg *config.Config) *Client {
+	network, address := ParseAddress(cfg.FastCGIAddress)
+
+	docRoot := cfg.ProjectRoot
+	if abs, err := filepath.Abs(docRoot); err == nil {
+		docRoot = abs
+	}
+
+	script := cfg.FastCGIScript
+	if script == "" {
+		script = "index.php"
+	}
+	scriptFilename := script
+	if !filepath.IsAbs(scriptFilename) {
+		scriptFilename = filepath.Join(docRoot, script)
+	}
+
+	return &Client{
+		network:        network,
+		address:        address,
+		documentRoot:   docRoot,
+		scriptFilename: scriptFilename,
+		scriptName:     "/" + filepath.ToSlash(filepath.Base(script)),
+		multiplex:      cfg.FastCGIMultiplex,
+		dialTimeout:    5 * time.Second,
+	}
 }
 
-// ForwardRequest sends the HTTP request to a PHP worker and returns the response
+// ParseAddress splits a FastCGI address into a network and dial address.
+// "unix:/path" and bare paths are unix sockets, "tcp:host:port" and
+// "host:port" are TCP.
+func ParseAddress(addr string) (network, address string) {
+	switch {
+	case strings.HasPrefix(addr, "unix:"):
+		return "unix", strings.TrimPrefix(addr, "unix:")
+	case strings.HasPrefix(addr, "tcp:"):
+		return "tcp", strings.TrimPrefix(addr, "tcp:")
+	case strings.HasPrefix(addr, "/"), strings.HasPrefix(addr, "."):
+		return "unix", addr
+	default:
+		return "tcp", addr
+	}
+}
+
+// ForwardRequest sends the HTTP request to a PHP worker and returns the response.
+// Errors that occur before the response header is written are returned
+// as-is and nothing is written to w; later errors wrap ErrResponseStarted.
+// The request is aborted when r's context is done.
 func (c *Client) ForwardRequest(w http.ResponseWriter, r *http.Request) error {
-	// TODO: Implement FastCGI or custom protocol here
+	body, contentLength, err := requestBody(r)
+	if err != nil {
+		return err
+	}
+
+	cn, req, stdout, err := c.acquire()
+	if err != nil {
+		return err
+	}
+	defer c.release(cn)
+	defer stdout.Close()
+
+	// A client that went away aborts the request, so the backend does not
+	// keep working (or waiting for stdin) for nobody. The abort must be
+	// over before the connection is released for reuse.
+	aborted := make(chan struct{})
+	stop := context.AfterFunc(r.Context(), func() {
+		c.abort(cn, req)
+		close(aborted)
+	})
+	defer func() {
+		if !stop() {
+			<-aborted
+		}
+	}()
+
+	if err := cn.writeBeginRequest(req.id); err != nil {
+		cn.close(err)
+		return fmt.Errorf("fastcgi: begin request: %w", err)
+	}
+	if err := cn.writeParams(req.id, c.params(r, contentLength)); err != nil {
+		cn.close(err)
+		return fmt.Errorf("fastcgi: write params: %w", err)
+	}
+
+	// Stream stdin concurrently so a backend that starts answering before it
+	// has consumed the whole body cannot deadlock us.
+	stdinErr := make(chan error, 1)
+	go func() {
+		stdinErr <- c.writeStdin(cn, req, body)
+	}()
+
+	br := bufio.NewReader(stdout)
+	hdr, err := textproto.NewReader(br).ReadMIMEHeader()
+	if err != nil && !(errors.Is(err, io.EOF) && len(hdr) > 0) {
+		c.abort(cn, req)
+		<-stdinErr
+		return fmt.Errorf("fastcgi: read response header: %w", err)
+	}
+
+	status := http.StatusOK
+	if s := hdr.Get("Status"); s != "" {
+		code, convErr := strconv.Atoi(strings.SplitN(s, " ", 2)[0])
+		if convErr != nil || code < 100 || code > 999 {
+			c.abort(cn, req)
+			<-stdinErr
+			return fmt.Errorf("fastcgi: invalid status %q", s)
+		}
+		status = code
+		hdr.Del("Status")
+	} else if hdr.Get("Location") != "" {
+		status = http.StatusFound
+	}
+
+	for k, vals := range hdr {
+		for _, v := range vals {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(status)
+
+	if _, err := io.Copy(w, br); err != nil {
+		c.abort(cn, req)
+		<-stdinErr
+		return fmt.Errorf("%w: %v", ErrResponseStarted, err)
+	}
+
+	<-req.done
+	if err := <-stdinErr; err != nil {
+		return fmt.Errorf("%w: %v", ErrResponseStarted, err)
+	}
+	logStderr(req.stderr)
+
+	if req.err != nil {
+		return fmt.Errorf("%w: %v", ErrResponseStarted, req.err)
+	}
+	if req.protocolStatus != statusRequestComplete {
+		return fmt.Errorf("%w: backend refused request (protocol status %d)", ErrResponseStarted, req.protocolStatus)
+	}
+	return nil
+}
+
+// Close closes all connections to the backend
+func (c *Client) Close() error {
+	c.mu.Lock()
+	conns := c.conns
+	c.conns = nil
+	c.mu.Unlock()
+
+	for _, cn := range conns {
+		cn.close(errors.New("fastcgi: client closed"))
+	}
+	return nil
+}
+
+// acquire picks a connection with spare capacity (dialing a new one if
+// needed) and registers a request on it
+func (c *Client) acquire() (*conn, *request, *stdoutBuffer, error) {
+	for {
+		c.mu.Lock()
+		for _, cn := range c.conns {
+			if cn.broken() || (!c.multiplex && cn.inFlight() > 0) {
+				continue
+			}
+			if req, stdout, err := cn.register(); err == nil {
+				c.mu.Unlock()
+				return cn, req, stdout, nil
+			}
+		}
+
+		// Requests to a multiplexing backend share the connection being
+		// dialed rather than each opening their own
+		if c.multiplex && c.dialing != nil {
+			dialing := c.dialing
+			c.mu.Unlock()
+			<-dialing
+			continue
+		}
+		dialed := make(chan struct{})
+		if c.multiplex {
+			c.dialing = dialed
+		}
+		c.mu.Unlock()
+
+		// Dial without the lock so requests on other connections proceed
+		cn, req, stdout, err := c.dial()
+
+		c.mu.Lock()
+		if err == nil {
+			c.conns = append(c.conns, cn)
+		}
+		if c.dialing == dialed {
+			c.dialing = nil
+		}
+		c.mu.Unlock()
+		close(dialed)
+		return cn, req, stdout, err
+	}
+}
+
+// dial opens a new connection and registers a request on it
+func (c *Client) dial() (*conn, *request, *stdoutBuffer, error) {
+	nc, err := net.DialTimeout(c.network, c.address, c.dialTimeout)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("fastcgi: dial %s %s: %w", c.network, c.address, err)
+	}
+	cn := newConn(nc, c.multiplex)
+
+	req, stdout, err := cn.register()
+	if err != nil {
+		cn.close(err)
+		return nil, nil, nil, err
+	}
+	return cn, req, stdout, nil
+}
+
+// release drops broken connections and trims the idle set
+func (c *Client) release(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	idle := 0
+	kept := c.conns[:0]
+	for _, candidate := range c.conns {
+		if candidate.broken() {
+			continue
+		}
+		if candidate.inFlight() == 0 {
+			idle++
+			if idle > maxIdleConns {
+				candidate.close(errors.New("fastcgi: idle connection closed"))
+				continue
+			}
+		}
+		kept = append(kept, candidate)
+	}
+	c.conns = kept
+}
+
+// abort gives up on a request. Backends that do not multiplex (php-fpm) may
+// keep writing the abandoned response, so their connection is dropped.
+// Multiplexing backends are told to abort, but the request fails right away
+// as they may never answer.
+func (c *Client) abort(cn *conn, req *request) {
+	err := errors.New("fastcgi: request aborted")
+	if !c.multiplex {
+		cn.close(err)
+		return
+	}
+	if werr := cn.writeRecord(typeAbortRequest, req.id, nil); werr != nil {
+		cn.close(werr)
+	}
+	cn.drop(req, err)
+}
+
+// writeStdin streams the request body followed by the empty terminator.
+// If the body cannot be read the request is aborted, as the backend would
+// otherwise wait for the rest of stdin.
+func (c *Client) writeStdin(cn *conn, req *request, body io.Reader) error {
+	id := req.id
+	if body != nil {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := body.Read(buf)
+			if n > 0 {
+				if werr := cn.writeRecord(typeStdin, id, buf[:n]); werr != nil {
+					cn.close(werr)
+					return fmt.Errorf("fastcgi: write stdin: %w", werr)
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				c.abort(cn, req)
+				return fmt.Errorf("fastcgi: read request body: %w", err)
+			}
+		}
+	}
+	if err := cn.writeRecord(typeStdin, id, nil); err != nil {
+		cn.close(err)
+		return fmt.Errorf("fastcgi: write stdin: %w", err)
+	}
 	return nil
 }
+
+// params maps the HTTP request onto the CGI/1.1 environment
+func (c *Client) params(r *http.Request, contentLength int64) map[string]string {
+	host, port, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+		port = "80"
+		if r.TLS != nil {
+			port = "443"
+		}
+	}
+	remoteAddr, remotePort, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remoteAddr = r.RemoteAddr
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	params := map[string]string{
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"SERVER_SOFTWARE":   "tusk",
+		"SERVER_PROTOCOL":   r.Proto,
+		"SERVER_NAME":       host,
+		"SERVER_PORT":       port,
+		"REQUEST_METHOD":    r.Method,
+		"REQUEST_URI":       r.RequestURI,
+		"REQUEST_SCHEME":    scheme,
+		"QUERY_STRING":      r.URL.RawQuery,
+		"DOCUMENT_ROOT":     c.documentRoot,
+		"DOCUMENT_URI":      c.scriptName,
+		"SCRIPT_FILENAME":   c.scriptFilename,
+		"SCRIPT_NAME":       c.scriptName,
+		"REMOTE_ADDR":       remoteAddr,
+		"REMOTE_PORT":       remotePort,
+		"CONTENT_LENGTH":    strconv.FormatInt(contentLength, 10),
+		"CONTENT_TYPE":      r.Header.Get("Content-Type"),
+	}
+	if r.TLS != nil {
+		params["HTTPS"] = "on"
+	}
+
+	for k, v := range r.Header {
+		name := "HTTP_" + strings.ToUpper(strings.ReplaceAll(k, "-", "_"))
+		switch name {
+		case "HTTP_CONTENT_TYPE", "HTTP_CONTENT_LENGTH", "HTTP_PROXY":
+			// Passed as CONTENT_* above; HTTP_PROXY is dropped (httpoxy)
+			continue
+		}
+		params[name] = strings.Join(v, ", ")
+	}
+	if _, ok := params["HTTP_HOST"]; !ok && r.Host != "" {
+		params["HTTP_HOST"] = r.Host
+	}
+
+	return params
+}
+
+// requestBody returns the body reader and its length. PHP needs an accurate
+// CONTENT_LENGTH, so bodies of unknown length are buffered first.
+func requestBody(r *http.Request) (io.Reader, int64, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, 0, nil
+	}
+	if r.ContentLength >= 0 {
+		return r.Body, r.ContentLength, nil
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err := io.Copy(buf, r.Body); err != nil {
+		return nil, 0, fmt.Errorf("failed to read request body: %w", err)
+	}
+	return buf, int64(buf.Len()), nil
+}
+
+// logStderr relays anything the backend wrote to FCGI_STDERR
+func logStderr(stderr []byte) {
+	for _, line := range strings.Split(strings.TrimSpace(string(stderr)), "\n") {
+		if line != "" {
+			slog.Warn("FastCGI stderr", "line", line)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package ipc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tusk-framework/tusk-engine/internal/config"
+)
+
+// startResponder serves handler over FastCGI and returns the listen address
+func startResponder(t *testing.T, network, address string, handler http.Handler) string {
+	t.Helper()
+
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go fcgi.Serve(ln, handler)
+
+	if network == "unix" {
+		return "unix:" + ln.Addr().String()
+	}
+	return ln.Addr().String()
+}
+
+func newTestClient(t *testing.T, addr string, multiplex bool) *Client {
+	t.Helper()
+
+	cfg := config.DefaultConfig()
+	cfg.FastCGIAddress = addr
+	cfg.FastCGIMultiplex = multiplex
+	cfg.ProjectRoot = "/srv/app"
+
+	c := NewClient(cfg)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestForwardRequestParams(t *testing.T) {
+	addr := startResponder(t, "tcp", "127.0.0.1:0", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		env := fcgi.ProcessEnv(r)
+		env["body"] = string(body)
+		env["header"] = r.Header.Get("X-Custom")
+		env["query"] = r.URL.RawQuery
+		env["content_length"] = fmt.Sprint(r.ContentLength)
+		env["method"] = r.Method
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Add("X-Multi", "a")
+		w.Header().Add("X-Multi", "b")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(env)
+	}))
+	client := newTestClient(t, addr, false)
+
+	req := httptest.NewRequest(http.MethodPost, "/users?page=2", strings.NewReader("name=tusk"))
+	req.Header.Set("X-Custom", "hello")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+
+	if err := client.ForwardRequest(rec, req); err != nil {
+		t.Fatalf("ForwardRequest failed: %v", err)
+	}
+
+	if rec.Code != http.StatusCreated {
+		t.Errorf("Expected status 201, got %d", rec.Code)
+	}
+	if got := rec.Header().Values("X-Multi"); len(got) != 2 {
+		t.Errorf("X-Multi header lost values: %v", got)
+	}
+	if rec.Header().Get("Status") != "" {
+		t.Errorf("Status pseudo-header leaked into response")
+	}
+
+	var env map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &env); err != nil {
+		t.Fatalf("Invalid response body %q: %v", rec.Body.String(), err)
+	}
+
+	expected := map[string]string{
+		"SCRIPT_FILENAME": filepath.Join("/srv/app", "index.php"),
+		"DOCUMENT_ROOT":   "/srv/app",
+		"query":           "page=2",
+		"content_length":  "9",
+		"method":          http.MethodPost,
+		"body":            "name=tusk",
+		"header":          "hello",
+	}
+	for k, want := range expected {
+		if env[k] != want {
+			t.Errorf("%s = %q, want %q", k, env[k], want)
+		}
+	}
+}
+
+func TestForwardRequestUnixSocket(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "fpm.sock")
+	addr := startResponder(t, "unix", sock, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "pong")
+	}))
+	client := newTestClient(t, addr, false)
+
+	// Run a few requests to exercise connection reuse
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		if err := client.ForwardRequest(rec, httptest.NewRequest(http.MethodGet, "/ping", nil)); err != nil {
+			t.Fatalf("Request %d failed: %v", i, err)
+		}
+		if rec.Body.String() != "pong" {
+			t.Errorf("Request %d: unexpected body %q", i, rec.Body.String())
+		}
+	}
+}
+
+func TestForwardRequestMultiplexed(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	release := make(chan struct{})
+
+	addr := startResponder(t, "tcp", "127.0.0.1:0", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		if inFlight == 4 {
+			close(release)
+		}
+		mu.Unlock()
+
+		<-release
+		fmt.Fprint(w, r.URL.Query().Get("n"))
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}))
+	client := newTestClient(t, addr, true)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			if err := client.ForwardRequest(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/?n=%d", n), nil)); err != nil {
+				errs <- err
+				return
+			}
+			if rec.Body.String() != fmt.Sprint(n) {
+				errs <- fmt.Errorf("request %d got body %q", n, rec.Body.String())
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+	if maxInFlight != 4 {
+		t.Errorf("Expected 4 concurrent requests, got %d", maxInFlight)
+	}
+
+	client.mu.Lock()
+	conns := len(client.conns)
+	client.mu.Unlock()
+	if conns != 1 {
+		t.Errorf("Expected requests to share one connection, got %d", conns)
+	}
+}
+
+// stalledWriter is a ResponseWriter whose client stops reading the body
+type stalledWriter struct {
+	header  http.Header
+	release chan struct{}
+}
+
+func (w *stalledWriter) Header() http.Header { return w.header }
+func (w *stalledWriter) WriteHeader(int)     {}
+func (w *stalledWriter) Write(b []byte) (int, error) {
+	<-w.release
+	return len(b), nil
+}
+
+func TestForwardRequestSlowConsumer(t *testing.T) {
+	large := strings.Repeat("x", 2*multiplexLimit)
+	written := make(chan struct{})
+	addr := startResponder(t, "tcp", "127.0.0.1:0", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("large") {
+			fmt.Fprint(w, large)
+			close(written)
+			return
+		}
+		fmt.Fprint(w, "small")
+	}))
+	client := newTestClient(t, addr, true)
+
+	stalled := &stalledWriter{header: make(http.Header), release: make(chan struct{})}
+	slow := make(chan error, 1)
+	go func() {
+		slow <- client.ForwardRequest(stalled, httptest.NewRequest(http.MethodGet, "/?large=1", nil))
+	}()
+
+	// Requests sharing the connection are not held up by the stalled one
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		if err := client.ForwardRequest(rec, httptest.NewRequest(http.MethodGet, "/", nil)); err != nil {
+			t.Fatalf("Request %d failed: %v", i, err)
+		}
+		if rec.Body.String() != "small" {
+			t.Errorf("Request %d got body %q", i, rec.Body.String())
+		}
+	}
+
+	// Once the backend wrote it all, more than multiplexLimit is buffered
+	<-written
+	close(stalled.release)
+	if err := <-slow; err == nil || !strings.Contains(err.Error(), errSlowConsumer.Error()) {
+		t.Errorf("Expected the stalled request to fail as too slow, got %v", err)
+	}
+}
+
+func TestCloseDuringRequests(t *testing.T) {
+	addr := startResponder(t, "tcp", "127.0.0.1:0", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	client := newTestClient(t, addr, true)
+
+	// Requests finishing while the client tears their connection down
+	// must each be completed exactly once
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client.ForwardRequest(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		}()
+		if i%10 == 5 {
+			client.Close()
+		}
+	}
+	wg.Wait()
+}
+
+// brokenBody fails after the first chunk, like a client that disconnects
+// during an upload
+type brokenBody struct{ sent bool }
+
+func (b *brokenBody) Read(p []byte) (int, error) {
+	if b.sent {
+		return 0, errors.New("connection reset by peer")
+	}
+	b.sent = true
+	return copy(p, "partial"), nil
+}
+
+func TestForwardRequestBodyError(t *testing.T) {
+	addr := startResponder(t, "tcp", "127.0.0.1:0", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		fmt.Fprint(w, "ok")
+	}))
+	client := newTestClient(t, addr, false)
+
+	req := httptest.NewRequest(http.MethodPost, "/", &brokenBody{})
+	req.ContentLength = 100
+
+	// The backend must not be left waiting for the rest of stdin
+	done := make(chan error, 1)
+	go func() { done <- client.ForwardRequest(httptest.NewRecorder(), req) }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Expected an error for a broken request body")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("ForwardRequest hung after the request body failed")
+	}
+}
+
+func TestForwardRequestCancelled(t *testing.T) {
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	addr := startResponder(t, "tcp", "127.0.0.1:0", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+
+	for _, multiplex := range []bool{false, true} {
+		client := newTestClient(t, addr, multiplex)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+		done := make(chan error, 1)
+		go func() { done <- client.ForwardRequest(httptest.NewRecorder(), req) }()
+
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Errorf("multiplex=%v: expected an error for a cancelled request", multiplex)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("multiplex=%v: ForwardRequest ignored the cancelled context", multiplex)
+		}
+	}
+}
+
+func TestForwardRequestDialError(t *testing.T) {
+	client := newTestClient(t, "unix:"+filepath.Join(t.TempDir(), "missing.sock"), false)
+
+	rec := httptest.NewRecorder()
+	err := client.ForwardRequest(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if err == nil {
+		t.Fatal("Expected dial error")
+	}
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Errorf("Nothing should be written on error, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		in, network, address string
+	}{
+		{"127.0.0.1:9000", "tcp", "127.0.0.1:9000"},
+		{"tcp:fpm:9000", "tcp", "fpm:9000"},
+		{"unix:/run/php/fpm.sock", "unix", "/run/php/fpm.sock"},
+		{"/run/php/fpm.sock", "unix", "/run/php/fpm.sock"},
+	}
+	for _, tt := range tests {
+		network, address := ParseAddress(tt.in)
+		if network != tt.network || address != tt.address {
+			t.Errorf("ParseAddress(%q) = %s %s, want %s %s", tt.in, network, address, tt.network, tt.address)
+		}
+	}
+}
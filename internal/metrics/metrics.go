@@ -1,6 +1,8 @@
 package metrics
 
 import (
+	"runtime"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
@@ -22,8 +24,126 @@ var (
 		Help: "Number of workers currently processing requests.",
 	})
 
+	RequestsWaiting = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "tusk_requests_waiting",
+		Help: "Number of requests waiting for a free worker.",
+	})
+
+	RequestsShed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tusk_requests_shed_total",
+		Help: "Total number of requests rejected under load, by reason (queue_full, queue_timeout).",
+	}, []string{"reason"})
+
+	QueueWait = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "tusk_queue_wait_seconds",
+		Help:    "Time requests spent waiting for a free worker.",
+		Buckets: []float64{.0001, .0005, .001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	})
+
+	WorkerExecution = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "tusk_worker_execution_seconds",
+		Help:    "Time from handing a request to a worker until its response arrived.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	RequestSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "tusk_request_size_bytes",
+		Help:    "Size of HTTP request bodies.",
+		Buckets: prometheus.ExponentialBuckets(64, 4, 10),
+	})
+
+	ResponseSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "tusk_response_size_bytes",
+		Help:    "Size of HTTP response bodies.",
+		Buckets: prometheus.ExponentialBuckets(64, 4, 10),
+	})
+
 	WorkersTotal = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "tusk_workers_total",
 		Help: "Total number of workers in the pool.",
 	})
+
+	WorkerTimeouts = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "tusk_worker_timeouts_total",
+		Help: "Total number of requests that exceeded the request timeout.",
+	})
+
+	RequestRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "tusk_request_retries_total",
+		Help: "Total number of requests retried on another worker after theirs died.",
+	})
+
+	RequestsCancelled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tusk_requests_cancelled_total",
+		Help: "Total number of requests abandoned by their client, by stage (queue, worker).",
+	}, []string{"stage"})
+
+	WorkerExits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tusk_worker_exits_total",
+		Help: "Total number of unexpected worker exits, by reason (exit, crash, signal, killed).",
+	}, []string{"reason"})
+
+	WorkerRestarts = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "tusk_worker_restarts_total",
+		Help: "Total number of workers respawned after an unexpected exit.",
+	})
+
+	WorkerSpawnFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "tusk_worker_spawn_failures_total",
+		Help: "Total number of failed attempts to start a replacement worker.",
+	})
+
+	CrashLoop = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "tusk_crash_loop",
+		Help: "1 while workers are crash looping and requests are rejected.",
+	})
+
+	WorkerBoot = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "tusk_worker_boot_seconds",
+		Help:    "Time from spawning a worker until it reported ready.",
+		Buckets: []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	})
+
+	WorkerBootFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "tusk_worker_boot_failures_total",
+		Help: "Total number of workers that failed to become ready.",
+	})
+
+	WorkerProtocolErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "tusk_worker_protocol_errors_total",
+		Help: "Total number of worker responses rejected as invalid.",
+	})
+
+	WorkerHealthFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "tusk_worker_health_failures_total",
+		Help: "Total number of workers replaced after failing a health check.",
+	})
+
+	WorkerRecycles = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tusk_worker_recycles_total",
+		Help: "Total number of workers gracefully recycled, by reason.",
+	}, []string{"reason"})
+
+	PHPErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tusk_php_errors_total",
+		Help: "Total number of PHP errors reported on worker stderr, by level.",
+	}, []string{"level"})
+
+	WebSocketConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "tusk_websocket_connections",
+		Help: "Number of open WebSocket connections.",
+	})
 )
+
+// Version is the engine version reported by tusk_build_info; release
+// builds set it with -ldflags "-X .../internal/metrics.Version=..."
+var Version = "dev"
+
+var BuildInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "tusk_build_info",
+	Help: "Build information about the running engine; always 1.",
+}, []string{"version", "goversion"})
+
+func init() {
+	BuildInfo.WithLabelValues(Version, runtime.Version()).Set(1)
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/tusk-framework/tusk-engine/internal/accesslog"
+	"github.com/tusk-framework/tusk-engine/internal/metrics"
+	"github.com/tusk-framework/tusk-engine/internal/worker"
+)
+
+// observeRequests records request and response sizes and writes an access
+// log entry for every request once it has been served
+func (s *Server) observeRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		dispatch := &worker.Dispatch{
+			RequestID: requestID(r.Context()),
+			WorkerID:  -1,
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		body := &countingBody{ReadCloser: r.Body}
+		r.Body = body
+
+		next.ServeHTTP(rec, r.WithContext(worker.WithDispatch(r.Context(), dispatch)))
+
+		metrics.RequestSize.Observe(float64(body.n))
+		metrics.ResponseSize.Observe(float64(rec.bytes))
+		if s.access == nil {
+			return
+		}
+		s.access.Log(&accesslog.Entry{
+			Time:       start,
+			RemoteAddr: r.RemoteAddr,
+			Method:     r.Method,
+			URI:        r.RequestURI,
+			Proto:      r.Proto,
+			Status:     rec.status,
+			Bytes:      rec.bytes,
+			Duration:   time.Since(start),
+			Upstream:   dispatch.Latency,
+			RequestID:  dispatch.RequestID,
+			WorkerID:   dispatch.WorkerID,
+			Referer:    r.Referer(),
+			UserAgent:  r.UserAgent(),
+		})
+	})
+}
+
+// countingBody counts the request body bytes read by the handler
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tusk-framework/tusk-engine/internal/accesslog"
+	"github.com/tusk-framework/tusk-engine/internal/config"
+)
+
+func TestAccessLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	cfg := config.DefaultConfig()
+	cfg.AccessLog = path
+	cfg.AccessLogFormat = accesslog.FormatJSON
+	s := NewServer(cfg, newTestPool(t, cfg))
+
+	access, err := accesslog.New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create access log: %v", err)
+	}
+	s.access = access
+
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/hello", nil)
+	req.Header.Set("X-Request-Id", "req-1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	access.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("Invalid access log line %q: %v", data, err)
+	}
+
+	if entry["uri"] != "/hello" || entry["status"] != float64(200) || entry["request_id"] != "req-1" {
+		t.Errorf("Unexpected entry %v", entry)
+	}
+	if entry["worker_id"] != float64(0) || entry["bytes"] != float64(2) {
+		t.Errorf("Expected worker 0 and 2 body bytes, got %v", entry)
+	}
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/tusk-framework/tusk-engine/internal/ipc"
+	"github.com/tusk-framework/tusk-engine/internal/worker"
+)
+
+// adminKey marks requests that arrived on the admin listener
+type adminKey struct{}
+
+// startAdmin serves the admin API on admin_address, either "host:port" or
+// a "unix:/path" socket
+func (s *Server) startAdmin() error {
+	network, address := ipc.ParseAddress(s.cfg.AdminAddress)
+	if network == "unix" {
+		// Left behind by an unclean shutdown
+		if fi, err := os.Lstat(address); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(address)
+		}
+	}
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return fmt.Errorf("admin listener: %w", err)
+	}
+
+	s.admin = &http.Server{Handler: s.adminHandler()}
+	go func() {
+		slog.Info("Admin listener started", "addr", ln.Addr().String())
+		if err := s.admin.Serve(ln); err != nil && err != http.ErrServerClosed {
+			slog.Error("Admin listener failed", "err", err)
+		}
+	}()
+	return nil
+}
+
+// adminHandler builds the router for the admin listener. Every route
+// requires admin_token when one is configured.
+func (s *Server) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/readyz", s.handleReadyz)
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	if s.pool != nil {
+		mux.HandleFunc("/status", s.handleStatus)
+		mux.HandleFunc("/reload", s.handleReload)
+		mux.HandleFunc("/scale", s.handleScale)
+		mux.HandleFunc("/workers/{id}/kill", s.handleKillWorker)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(context.WithValue(r.Context(), adminKey{}, true))
+		if !s.authorized(r) {
+			unauthorized(w)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// handleStatus lists the pool's workers
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, s.pool.Status())
+}
+
+// handleReload triggers a rolling restart of the worker pool
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		unauthorized(w)
+		return
+	}
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	slog.Info("Reload requested", "remote_addr", r.RemoteAddr)
+	gen := s.pool.Reload()
+
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"status":     "reloading",
+		"generation": gen,
+	})
+}
+
+// handleScale resizes the pool to the "workers" form value
+func (s *Server) handleScale(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	n, err := strconv.Atoi(r.FormValue("workers"))
+	if err != nil {
+		http.Error(w, "workers must be a number", http.StatusBadRequest)
+		return
+	}
+
+	size, err := s.pool.Scale(n)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, worker.ErrPoolSize) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"workers": size})
+}
+
+// handleKillWorker kills one worker, which is then respawned
+func (s *Server) handleKillWorker(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid worker id", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.pool.KillWorker(id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"status": "killed",
+		"worker": id,
+	})
+}
+
+// authorized checks the request's bearer token against admin_token.
+// Without a token only requests on the admin listener are trusted.
+func (s *Server) authorized(r *http.Request) bool {
+	if s.cfg.AdminToken == "" {
+		return r.Context().Value(adminKey{}) != nil
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.AdminToken)) == 1
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
+
+// allowMethod rejects requests with any other method with a 405
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tusk-framework/tusk-engine/internal/config"
+	"github.com/tusk-framework/tusk-engine/internal/worker"
+)
+
+func TestReloadRequiresToken(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.AdminToken = "s3cret"
+	s := NewServer(cfg, nil)
+
+	tests := []struct {
+		method string
+		auth   string
+		want   int
+	}{
+		{http.MethodPost, "", http.StatusUnauthorized},
+		{http.MethodPost, "Bearer wrong", http.StatusUnauthorized},
+		{http.MethodPost, "s3cret", http.StatusUnauthorized},
+		{http.MethodGet, "Bearer s3cret", http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/_tusk/reload", nil)
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		rec := httptest.NewRecorder()
+		s.handleReload(rec, req)
+
+		if rec.Code != tt.want {
+			t.Errorf("%s with %q: expected %d, got %d", tt.method, tt.auth, tt.want, rec.Code)
+		}
+	}
+}
+
+func TestMetricsOnAdminListener(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.AdminAddress = "127.0.0.1:0"
+	s := NewServer(cfg, newTestPool(t, cfg))
+
+	rec := httptest.NewRecorder()
+	s.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "tusk_build_info{") {
+		t.Errorf("admin /metrics: got %d without tusk_build_info", rec.Code)
+	}
+
+	// The application owns /metrics once the admin listener serves it
+	rec = httptest.NewRecorder()
+	s.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if strings.Contains(rec.Body.String(), "tusk_build_info") {
+		t.Error("app /metrics still served by the engine")
+	}
+	if rec.Body.String() != "ok" {
+		t.Errorf("app /metrics: expected worker response, got %q", rec.Body.String())
+	}
+}
+
+func TestAdminAPI(t *testing.T) {
+	cfg := config.DefaultConfig()
+	sock := filepath.Join(t.TempDir(), "admin.sock")
+	cfg.AdminAddress = "unix:" + sock
+	cfg.AdminToken = "s3cret"
+	s := NewServer(cfg, newTestPool(t, cfg))
+	if err := s.startAdmin(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.admin.Close() })
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", sock)
+		},
+	}}
+	call := func(method, path, token string) (*http.Response, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, "http://admin"+path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	if resp, _ := call(http.MethodGet, "/healthz", ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("healthz without token: expected 401, got %d", resp.StatusCode)
+	}
+	if resp, body := call(http.MethodGet, "/healthz", "s3cret"); resp.StatusCode != http.StatusOK || body != "ok\n" {
+		t.Errorf("healthz: got %d %q", resp.StatusCode, body)
+	}
+	if resp, _ := call(http.MethodGet, "/debug/pprof/cmdline", "s3cret"); resp.StatusCode != http.StatusOK {
+		t.Errorf("pprof: expected 200, got %d", resp.StatusCode)
+	}
+
+	status := func() worker.PoolStatus {
+		t.Helper()
+		resp, body := call(http.MethodGet, "/status", "s3cret")
+		var st worker.PoolStatus
+		if err := json.Unmarshal([]byte(body), &st); err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("status: got %d %q", resp.StatusCode, body)
+		}
+		return st
+	}
+	st := status()
+	if len(st.Workers) != 1 || st.Workers[0].State != worker.StateIdle || st.Workers[0].PID == 0 {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+	pid := st.Workers[0].PID
+
+	if resp, _ := call(http.MethodPost, "/scale?workers=5", "s3cret"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("scale beyond max: expected 400, got %d", resp.StatusCode)
+	}
+	if resp, _ := call(http.MethodPost, "/workers/7/kill", "s3cret"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("kill unknown worker: expected 404, got %d", resp.StatusCode)
+	}
+	if resp, _ := call(http.MethodPost, "/workers/0/kill", "s3cret"); resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("kill: expected 202, got %d", resp.StatusCode)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		st = status()
+		if len(st.Workers) == 1 && st.Workers[0].PID != pid {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("worker was not respawned: %+v", st)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestAdminWithoutToken(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.AdminAddress = "127.0.0.1:0"
+	s := NewServer(cfg, nil)
+
+	// The admin listener is trusted, the app port is not
+	rec := httptest.NewRecorder()
+	s.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("admin healthz: expected 200, got %d", rec.Code)
+	}
+	if s.authorized(httptest.NewRequest(http.MethodPost, "/_tusk/reload", nil)) {
+		t.Error("request outside the admin listener authorized without a token")
+	}
+}
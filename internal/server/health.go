@@ -0,0 +1,27 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// handleHealthz reports that the engine is up
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// handleReadyz reports whether the engine can take traffic: it is not
+// shutting down and the pool has enough healthy workers
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	switch {
+	case s.stopping.Load():
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+	case s.pool != nil && !s.pool.Ready():
+		msg := fmt.Sprintf("not enough healthy workers (%d)", s.pool.HealthyWorkers())
+		http.Error(w, msg, http.StatusServiceUnavailable)
+	default:
+		w.Write([]byte("ok\n"))
+	}
+}
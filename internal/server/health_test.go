@@ -0,0 +1,126 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tusk-framework/tusk-engine/internal/config"
+	"github.com/tusk-framework/tusk-engine/internal/metrics"
+	"github.com/tusk-framework/tusk-engine/internal/worker"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	cfg := config.DefaultConfig()
+	s := NewServer(cfg, newTestPool(t, cfg))
+	h := s.handler()
+
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	if code := get("/healthz"); code != http.StatusOK {
+		t.Errorf("healthz: expected 200, got %d", code)
+	}
+	if code := get("/readyz"); code != http.StatusOK {
+		t.Errorf("readyz: expected 200, got %d", code)
+	}
+
+	// Load balancers should stop sending traffic during shutdown
+	s.stopping.Store(true)
+	if code := get("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("readyz while stopping: expected 503, got %d", code)
+	}
+	if code := get("/healthz"); code != http.StatusOK {
+		t.Errorf("healthz while stopping: expected 200, got %d", code)
+	}
+}
+
+func TestCrashLoopPage(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.RestartBackoff = config.Duration(20 * time.Millisecond)
+	cfg.RestartBackoffMax = config.Duration(50 * time.Millisecond)
+	cfg.CrashLoopThreshold = 2
+	cfg.Debug = true
+	pool := newTestPool(t, cfg)
+	s := NewServer(cfg, pool)
+	h := s.handler()
+
+	// Broken code is deployed and the worker's replacements fail to boot
+	t.Setenv("TUSK_TEST_BOOT", "crash")
+	if err := pool.KillWorker(0); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code == http.StatusServiceUnavailable {
+			body := rec.Body.String()
+			if !strings.Contains(body, "crash looping") || !strings.Contains(body, "PHP Parse error") {
+				t.Errorf("unexpected crash loop page: %q", body)
+			}
+			if rec.Header().Get("Retry-After") == "" {
+				t.Error("missing Retry-After header")
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a 503 crash loop page, last status %d", rec.Code)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("readyz while crash looping: expected 503, got %d", rec.Code)
+	}
+}
+
+func TestLoadShedding(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.MaxQueueWait = config.Duration(50 * time.Millisecond)
+	pool := newTestPool(t, cfg)
+	s := NewServer(cfg, pool)
+	h := s.handler()
+
+	// Keep the only worker busy so the next request has to queue
+	busy := make(chan error, 1)
+	go func() {
+		_, err := pool.HandleRequest(&worker.Request{Query: "sleep=300"}, nil)
+		busy <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	shed := metrics.RequestsTotal.WithLabelValues(http.MethodGet, "503")
+	before := testutil.ToFloat64(shed)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d", rec.Code)
+	}
+	if got := testutil.ToFloat64(shed) - before; got != 1 {
+		t.Errorf("expected the shed request to be counted once, got %v", got)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("expected Retry-After: 1, got %q", got)
+	}
+
+	// Probes never wait for a worker
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("healthz under load: expected 200, got %d", rec.Code)
+	}
+
+	if err := <-busy; err != nil {
+		t.Errorf("busy request failed: %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// maxRequestIDLength bounds client supplied request IDs
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// withRequestID accepts the client's request ID header or generates one,
+// sets it on the request (so FastCGI backends see it too) and echoes it in
+// the response
+func (s *Server) withRequestID(next http.Handler) http.Handler {
+	header := s.cfg.RequestIDHeader
+	if header == "" {
+		header = "X-Request-ID"
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(header)
+		if !validRequestID(id) {
+			id = newRequestID()
+			r.Header.Set(header, id)
+		}
+		w.Header().Set(header, id)
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// requestID returns the ID assigned by withRequestID
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID accepts short IDs of printable ASCII, so a client cannot
+// inject spaces or control characters into log lines
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random 128-bit hex ID
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
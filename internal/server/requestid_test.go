@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tusk-framework/tusk-engine/internal/config"
+)
+
+func TestRequestID(t *testing.T) {
+	cfg := config.DefaultConfig()
+	s := NewServer(cfg, nil)
+
+	var seen string
+	handler := s.withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = requestID(r.Context())
+		if got := requestEnvelope(r).RequestID; got != seen {
+			t.Errorf("Envelope carries %v, want %q", got, seen)
+		}
+		if got := r.Header.Get("X-Request-ID"); got != seen {
+			t.Errorf("Request header is %q, want %q", got, seen)
+		}
+	}))
+
+	tests := []struct {
+		incoming string
+		keep     bool
+	}{
+		{"", false},
+		{"abc-123", true},
+		{"has space", false},
+		{strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.incoming != "" {
+			req.Header.Set("X-Request-ID", tt.incoming)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if seen == "" || rec.Header().Get("X-Request-ID") != seen {
+			t.Errorf("%q: response header %q does not match request ID %q", tt.incoming, rec.Header().Get("X-Request-ID"), seen)
+		}
+		if (seen == tt.incoming) != tt.keep {
+			t.Errorf("%q: got request ID %q, keep=%v", tt.incoming, seen, tt.keep)
+		}
+	}
+}
+
+func TestRequestIDCustomHeader(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.RequestIDHeader = "X-Correlation-ID"
+	s := NewServer(cfg, nil)
+
+	handler := s.withRequestID(http.NotFoundHandler())
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Correlation-ID", "corr-1")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get("X-Correlation-ID"); got != "corr-1" {
+		t.Errorf("Expected X-Correlation-ID to be echoed, got %q", got)
+	}
+	if rec.Header().Get("X-Request-ID") != "" {
+		t.Error("Default header should not be set when a custom one is configured")
+	}
+}
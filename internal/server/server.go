@@ -1,121 +1,441 @@
 package server
 
 import (
+	"bufio"
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"path"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/tusk-framework/tusk-engine/internal/accesslog"
 	"github.com/tusk-framework/tusk-engine/internal/config"
+	"github.com/tusk-framework/tusk-engine/internal/ipc"
 	"github.com/tusk-framework/tusk-engine/internal/metrics"
+	"github.com/tusk-framework/tusk-engine/internal/tracing"
 	"github.com/tusk-framework/tusk-engine/internal/worker"
+	"go.opentelemetry.io/otel/propagation"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 // Server is the HTTP server for Tusk
 type Server struct {
-	cfg  *config.Config
-	pool *worker.Pool
-	http *http.Server
+	cfg    *config.Config
+	pool   *worker.Pool
+	fcgi   *ipc.Client
+	static *staticFiles
+	certs  *certStore
+	hub    *wsHub
+	access *accesslog.Logger
+	http   *http.Server
+	plain  *http.Server // HTTP to HTTPS redirect listener
+	admin  *http.Server // admin API listener, see AdminAddress
+
+	stopping atomic.Bool // fails readiness during a graceful shutdown
 }
 
-// NewServer creates a new HTTP server
+// NewServer creates a new HTTP server. When a FastCGI address is configured,
+// requests are forwarded to that backend and pool may be nil.
 func NewServer(cfg *config.Config, pool *worker.Pool) *Server {
-	return &Server{
+	s := &Server{
 		cfg:  cfg,
 		pool: pool,
+		hub:  newWSHub(),
+	}
+	if cfg.FastCGIAddress != "" {
+		s.fcgi = ipc.NewClient(cfg)
 	}
+	if cfg.PublicDir != "" {
+		s.static = newStaticFiles(cfg)
+	}
+	return s
 }
 
 // Start starts the HTTP server
 func (s *Server) Start() error {
-	mux := http.NewServeMux()
+	access, err := accesslog.New(s.cfg)
+	if err != nil {
+		return err
+	}
+	s.access = access
 
-	mux.Handle("/metrics", promhttp.Handler())
-	mux.HandleFunc("/", s.handleRequest)
+	if s.cfg.AdminAddress != "" {
+		if err := s.startAdmin(); err != nil {
+			return err
+		}
+	}
 
 	addr := fmt.Sprintf("%s:%d", s.cfg.Address, s.cfg.Port)
 	s.http = &http.Server{
 		Addr:    addr,
-		Handler: mux,
+		Handler: s.handler(),
+	}
+	if !s.cfg.HTTP2 {
+		// A non-nil empty map turns off the automatic HTTP/2 upgrade
+		s.http.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
 	}
 
-	fmt.Printf("Tusk Engine listening on %s\n", addr)
-	return s.http.ListenAndServe()
+	if !tlsEnabled(s.cfg) {
+		slog.Info("Tusk Engine listening", "addr", addr)
+		return s.http.ListenAndServe()
+	}
+
+	certs, err := newCertStore(s.cfg)
+	if err != nil {
+		return err
+	}
+	s.certs = certs
+	go certs.watch()
+	s.http.TLSConfig = &tls.Config{GetCertificate: certs.getCertificate}
+
+	if s.cfg.HTTPRedirectPort > 0 {
+		s.plain = &http.Server{
+			Addr:    fmt.Sprintf("%s:%d", s.cfg.Address, s.cfg.HTTPRedirectPort),
+			Handler: redirectHandler(s.cfg.Port),
+		}
+		go func() {
+			slog.Info("Redirecting HTTP to HTTPS", "addr", s.plain.Addr)
+			if err := s.plain.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				slog.Error("HTTP redirect listener failed", "err", err)
+			}
+		}()
+	}
+
+	slog.Info("Tusk Engine listening", "addr", addr, "tls", true)
+	return s.http.ListenAndServeTLS("", "")
+}
+
+// handler builds the request router
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	// Without an admin listener the engine's own routes share the app port
+	if s.cfg.AdminAddress == "" {
+		mux.Handle("/metrics", promhttp.Handler())
+		mux.HandleFunc("/healthz", s.handleHealthz)
+		mux.HandleFunc("/readyz", s.handleReadyz)
+		if s.cfg.AdminToken != "" && s.pool != nil {
+			mux.HandleFunc("/_tusk/reload", s.handleReload)
+		}
+	}
+	mux.HandleFunc("/", s.handleRequest)
+
+	handler := s.withRequestID(s.traceRequests(s.observeRequests(mux)))
+	if s.cfg.H2C {
+		// Accepts both prior-knowledge h2c and "Upgrade: h2c" requests
+		return h2c.NewHandler(handler, &http2.Server{})
+	}
+	return handler
 }
 
 // Stop stops the HTTP server gracefully
 func (s *Server) Stop(ctx context.Context) error {
+	s.stopping.Store(true)
+	// Shutdown does not track upgraded connections; close them first so
+	// their ws.close events still reach the workers
+	s.hub.shutdown(ctx)
 	if s.http == nil {
 		return nil
 	}
-	return s.http.Shutdown(ctx)
+	if s.plain != nil {
+		s.plain.Shutdown(ctx)
+	}
+	if s.admin != nil {
+		s.admin.Shutdown(ctx)
+	}
+	err := s.http.Shutdown(ctx)
+	if s.certs != nil {
+		s.certs.Close()
+	}
+	if s.fcgi != nil {
+		s.fcgi.Close()
+	}
+	if s.access != nil {
+		s.access.Close()
+	}
+	return err
 }
 
+// statusClientClosedRequest is logged for requests whose client went away
+// before the response was ready (as nginx does)
+const statusClientClosedRequest = 499
+
 func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
-	// 1. Extract Headers
-	headers := make(map[string][]string)
-	for k, v := range r.Header {
-		headers[k] = v
+	if s.static != nil && s.handleStatic(w, r) {
+		return
+	}
+	if s.fcgi != nil {
+		s.handleFastCGI(w, r)
+		return
 	}
 
-	// 2. Construct internal request metadata
-	req := map[string]interface{}{
-		"method":  r.Method,
-		"url":     r.RequestURI,
-		"headers": headers,
+	if len(s.cfg.WebSocketRoutes) > 0 && websocket.IsWebSocketUpgrade(r) && s.isWebSocketRoute(r.URL.Path) {
+		s.handleWebSocket(w, r)
+		return
 	}
 
+	// Every outcome is counted, including shed and failed requests
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	w = rec
+	defer func() {
+		metrics.RequestsTotal.WithLabelValues(r.Method, strconv.Itoa(rec.status)).Inc()
+	}()
+
+	// 1-2. Construct internal request metadata
+	req := requestEnvelope(r)
+	id := requestID(r.Context())
+
 	// 3. Forward to worker
+	// WorkersActive and the queue metrics are tracked by the pool, which
+	// knows when a worker is actually serving the request
 	start := time.Now()
-	metrics.WorkersActive.Inc()
-	defer metrics.WorkersActive.Dec()
+
+	ctx := r.Context()
+	if s.isPriorityPath(r.URL.Path) {
+		ctx = worker.WithPriority(ctx)
+	}
 
 	// r.Body implements io.ReadCloser which matches io.Reader
-	resp, err := s.pool.HandleRequest(req, r.Body)
+	resp, err := s.pool.HandleRequestContext(ctx, req, r.Body)
 	defer r.Body.Close()
 
 	duration := time.Since(start).Seconds()
 	metrics.RequestDuration.WithLabelValues(r.Method).Observe(duration)
 	if err != nil {
-		fmt.Printf("Engine Relay Error: %v\n", err)
-		http.Error(w, fmt.Sprintf("Engine Error: %v", err), http.StatusBadGateway)
+		var loop *worker.CrashLoopError
+		if errors.As(err, &loop) {
+			s.crashLoopPage(w, loop)
+			return
+		}
+		if errors.Is(err, context.Canceled) {
+			// Nobody reads the response, the status is for the access log
+			slog.Debug("Client went away", "method", r.Method, "uri", r.RequestURI, "request_id", id, "err", err)
+			w.WriteHeader(statusClientClosedRequest)
+			return
+		}
+		if errors.Is(err, worker.ErrQueueFull) || errors.Is(err, worker.ErrQueueTimeout) {
+			slog.Warn("Request shed", "method", r.Method, "uri", r.RequestURI, "request_id", id, "err", err)
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Service Unavailable: all workers are busy", http.StatusServiceUnavailable)
+			return
+		}
+		slog.Error("Worker relay failed", "method", r.Method, "uri", r.RequestURI, "request_id", id, "err", err)
+		status := http.StatusBadGateway
+		if errors.Is(err, worker.ErrTimeout) {
+			status = http.StatusGatewayTimeout
+		}
+		http.Error(w, fmt.Sprintf("Engine Error: %v", err), status)
+		return
+	}
+
+	// PHP may push to WebSocket connections from any request
+	s.hub.apply(resp.WS, nil)
+
+	// 5. Write the response. Headers set by the worker replace ones the
+	// engine set, such as the request ID.
+	for k, values := range resp.Headers {
+		w.Header().Del(k)
+		for _, v := range values {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(resp.Status)
+
+	if resp.Stream != nil {
+		if err := streamBody(w, resp.Stream); err != nil {
+			slog.Warn("Streaming response aborted", "uri", r.RequestURI, "request_id", id, "err", err)
+		}
+		return
+	}
+	w.Write(resp.Body)
+}
+
+// crashLoopPage answers with a 503 while workers are crash looping. The
+// failing worker's stderr is only shown in debug mode.
+func (s *Server) crashLoopPage(w http.ResponseWriter, loop *worker.CrashLoopError) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Retry-After", strconv.Itoa(int(loop.Window.Seconds())))
+	w.WriteHeader(http.StatusServiceUnavailable)
+
+	fmt.Fprintf(w, "Service Unavailable\n\nPHP workers are crash looping (%d crashes in %s); requests are rejected until they recover.\n",
+		loop.Crashes, loop.Window)
+	if !s.cfg.Debug {
 		return
 	}
+	fmt.Fprintf(w, "\nLast crash: worker %d, %s: %s\n", loop.Last.Worker, loop.Last.Reason, loop.Last.Error)
+	if len(loop.Last.Stderr) > 0 {
+		fmt.Fprintf(w, "\n%s\n", strings.Join(loop.Last.Stderr, "\n"))
+	}
+}
+
+// isPriorityPath reports whether urlPath matches one of priority_paths
+func (s *Server) isPriorityPath(urlPath string) bool {
+	for _, pattern := range s.cfg.PriorityPaths {
+		if ok, _ := path.Match(pattern, urlPath); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// requestEnvelope builds the request envelope sent to workers
+func requestEnvelope(r *http.Request) *worker.Request {
+	req := &worker.Request{
+		RequestID: requestID(r.Context()),
+		Method:    r.Method,
+		URI:       r.RequestURI,
+		Path:      r.URL.Path,
+		Query:     r.URL.RawQuery,
+		Protocol:  r.Proto,
+		Scheme:    "http",
+		Host:      r.Host,
+		Headers:   r.Header,
+	}
+	if r.TLS != nil {
+		req.Scheme = "https"
+	}
+	req.RemoteAddr, req.RemotePort = splitAddr(r.RemoteAddr)
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		_, req.ServerPort = splitAddr(addr.String())
+	}
+
+	// Like PHP's $_COOKIE, the first cookie of a name wins
+	for _, c := range r.Cookies() {
+		if req.Cookies == nil {
+			req.Cookies = make(map[string]string)
+		}
+		if _, ok := req.Cookies[c.Name]; !ok {
+			req.Cookies[c.Name] = c.Value
+		}
+	}
+	return req
+}
+
+// splitAddr splits a "host:port" address. Addresses without a port, such
+// as unix sockets, are returned whole with port 0.
+func splitAddr(addr string) (string, int) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, 0
+	}
+	n, _ := strconv.Atoi(port)
+	return host, n
+}
 
-	// 5. Parse response
-	if respHeaders, ok := resp["headers"].(map[string]interface{}); ok {
-		for k, v := range respHeaders {
-			switch val := v.(type) {
-			case string:
-				w.Header().Set(k, val)
-			case []interface{}:
-				for _, h := range val {
-					if str, ok := h.(string); ok {
-						w.Header().Add(k, str)
-					}
-				}
+// streamBody relays a streamed worker response, flushing every chunk so
+// downloads and Server-Sent Events reach the client as they are produced
+func streamBody(w http.ResponseWriter, body io.ReadCloser) error {
+	defer body.Close()
+
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			if flusher != nil {
+				flusher.Flush()
 			}
 		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// handleFastCGI relays the request to the configured FastCGI backend
+func (s *Server) handleFastCGI(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	metrics.WorkersActive.Inc()
+	defer metrics.WorkersActive.Dec()
+
+	// PHP sees the server span as its parent (HTTP_TRACEPARENT)
+	tracing.Propagator.Inject(r.Context(), propagation.HeaderCarrier(r.Header))
+
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	err := s.fcgi.ForwardRequest(rec, r)
+
+	elapsed := time.Since(start)
+	if d := worker.DispatchFromContext(r.Context()); d != nil {
+		d.Latency = elapsed
+	}
+	metrics.RequestDuration.WithLabelValues(r.Method).Observe(elapsed.Seconds())
+	switch {
+	case err == nil:
+	case r.Context().Err() != nil:
+		// Aborted because the client went away, as in handleRequest
+		slog.Debug("Client went away", "method", r.Method, "uri", r.RequestURI, "request_id", requestID(r.Context()), "err", err)
+		if !errors.Is(err, ipc.ErrResponseStarted) {
+			rec.WriteHeader(statusClientClosedRequest)
+		}
+	default:
+		slog.Error("FastCGI relay failed", "method", r.Method, "uri", r.RequestURI, "request_id", requestID(r.Context()), "err", err)
+		if !errors.Is(err, ipc.ErrResponseStarted) {
+			rec.status = http.StatusBadGateway
+			http.Error(w, fmt.Sprintf("Engine Error: %v", err), http.StatusBadGateway)
+		}
 	}
 
-	// Write Status
-	status := http.StatusOK
-	if statusVal, ok := resp["status"].(float64); ok {
-		status = int(statusVal)
+	metrics.RequestsTotal.WithLabelValues(r.Method, strconv.Itoa(rec.status)).Inc()
+}
+
+// statusRecorder remembers the status code and body size written by a
+// handler. It passes Flush and Hijack through so streaming and WebSocket
+// upgrades keep working behind it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if !r.wroteHeader {
+		r.status = code
+		r.wroteHeader = code >= 200 || code == http.StatusSwitchingProtocols
 	}
-	w.WriteHeader(status)
+	r.ResponseWriter.WriteHeader(code)
+}
 
-	metrics.RequestsTotal.WithLabelValues(r.Method, strconv.Itoa(status)).Inc()
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
 
-	// 5. Log Request
-	fmt.Printf("[%s] %s %s - %d (%.3fs)\n", time.Now().Format("2006-01-02 15:04:05"), r.Method, r.URL.Path, status, duration)
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
 
-	// Write Body
-	if body, ok := resp["body"].(string); ok {
-		w.Write([]byte(body))
-	} else {
-		w.Write([]byte("Invalid response body from worker"))
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("connection does not support hijacking")
 	}
+	r.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
 }
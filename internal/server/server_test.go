@@ -0,0 +1,182 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tusk-framework/tusk-engine/internal/config"
+	"github.com/tusk-framework/tusk-engine/internal/worker"
+	"golang.org/x/net/http2"
+)
+
+func requirePHP(tb testing.TB) {
+	tb.Helper()
+	if _, err := exec.LookPath("php"); err != nil {
+		tb.Skip("php binary not found in PATH")
+	}
+}
+
+// newTestPool starts a pool running the worker package's test worker. The
+// handshake is on, so the worker is ready once it returns.
+func newTestPool(t *testing.T, cfg *config.Config) *worker.Pool {
+	t.Helper()
+	requirePHP(t)
+
+	cfg.WorkerCount = 1
+	cfg.WorkerCommand = "test_worker.php"
+	cfg.ProjectRoot = "../worker"
+	cfg.WorkerHandshake = true
+
+	pool, err := worker.NewPool(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create pool: %v", err)
+	}
+	if err := pool.Start(); err != nil {
+		t.Fatalf("Failed to start pool: %v", err)
+	}
+	t.Cleanup(pool.Stop)
+	return pool
+}
+
+// h2cClient speaks cleartext HTTP/2 with prior knowledge
+func h2cClient() *http.Client {
+	return &http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		},
+	}
+}
+
+func TestH2C(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.H2C = true
+	s := NewServer(cfg, newTestPool(t, cfg))
+
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	resp, err := h2cClient().Get(ts.URL + "/")
+	if err != nil {
+		t.Fatalf("h2c request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.ProtoMajor != 2 {
+		t.Errorf("Expected HTTP/2, got %s", resp.Proto)
+	}
+	if got := resp.Header.Get("X-Protocol"); got != "HTTP/2.0" {
+		t.Errorf("Expected worker to see HTTP/2.0, got %q", got)
+	}
+
+	// Plain HTTP/1.1 clients keep working
+	resp, err = http.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatalf("HTTP/1.1 request failed: %v", err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("X-Protocol"); got != "HTTP/1.1" {
+		t.Errorf("Expected worker to see HTTP/1.1, got %q", got)
+	}
+}
+
+func TestH2CDisabled(t *testing.T) {
+	cfg := config.DefaultConfig()
+	s := NewServer(cfg, nil)
+
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	if _, err := h2cClient().Get(ts.URL + "/metrics"); err == nil {
+		t.Error("Expected h2c request to fail when h2c is disabled")
+	}
+}
+
+func TestHTTP2OverTLS(t *testing.T) {
+	cfg := config.DefaultConfig()
+	s := NewServer(cfg, newTestPool(t, cfg))
+
+	ts := httptest.NewUnstartedServer(s.handler())
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL + "/")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.ProtoMajor != 2 {
+		t.Errorf("Expected HTTP/2, got %s", resp.Proto)
+	}
+	if got := resp.Header.Get("X-Protocol"); got != "HTTP/2.0" {
+		t.Errorf("Expected worker to see HTTP/2.0, got %q", got)
+	}
+}
+
+func TestClientGoneAway(t *testing.T) {
+	cfg := config.DefaultConfig()
+	s := NewServer(cfg, newTestPool(t, cfg))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rec := httptest.NewRecorder()
+	s.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+	if rec.Code != statusClientClosedRequest {
+		t.Errorf("expected %d, got %d", statusClientClosedRequest, rec.Code)
+	}
+}
+
+func TestRequestEnvelope(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "https://example.com:8443/search?q=tusk", nil)
+	r.RemoteAddr = "192.0.2.7:51234"
+	r.Header.Add("Cookie", "session=abc; theme=dark; session=ignored")
+	r = r.WithContext(context.WithValue(r.Context(), http.LocalAddrContextKey, &net.TCPAddr{Port: 8443}))
+
+	req := requestEnvelope(r)
+	want := worker.Request{
+		Method:     http.MethodPost,
+		URI:        "https://example.com:8443/search?q=tusk",
+		Path:       "/search",
+		Query:      "q=tusk",
+		Protocol:   "HTTP/1.1",
+		Scheme:     "https",
+		Host:       "example.com:8443",
+		ServerPort: 8443,
+		RemoteAddr: "192.0.2.7",
+		RemotePort: 51234,
+	}
+	got := *req
+	got.Headers, got.Cookies = nil, nil
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("requestEnvelope() = %+v, want %+v", got, want)
+	}
+	if req.Cookies["session"] != "abc" || req.Cookies["theme"] != "dark" {
+		t.Errorf("unexpected cookies %v", req.Cookies)
+	}
+}
+
+func TestInvalidWorkerResponse(t *testing.T) {
+	cfg := config.DefaultConfig()
+	s := NewServer(cfg, newTestPool(t, cfg))
+
+	rec := httptest.NewRecorder()
+	s.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?invalid=status", nil))
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("expected 502, got %d", rec.Code)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, `status: expected an integer between 200 and 599, got string "ok"`) {
+		t.Errorf("502 does not explain the protocol error: %q", body)
+	}
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/tusk-framework/tusk-engine/internal/config"
+	"github.com/tusk-framework/tusk-engine/internal/metrics"
+)
+
+// precompressed lists the encodings tried for "<file>.<ext>" variants, in
+// order of preference
+var precompressed = []struct {
+	encoding, ext string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// staticFiles serves files from public_dir, like nginx's try_files in
+// front of a PHP front controller
+type staticFiles struct {
+	root         string
+	cacheControl string
+}
+
+func newStaticFiles(cfg *config.Config) *staticFiles {
+	root := cfg.PublicDir
+	if !filepath.IsAbs(root) {
+		root = filepath.Join(cfg.ProjectRoot, root)
+	}
+	return &staticFiles{root: root, cacheControl: cfg.StaticCacheControl}
+}
+
+// handleStatic serves the request from public_dir, reporting false when no
+// file matches and the request should go to PHP
+func (s *Server) handleStatic(w http.ResponseWriter, r *http.Request) bool {
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	if !s.static.serve(rec, r) {
+		return false
+	}
+
+	metrics.RequestsTotal.WithLabelValues(r.Method, strconv.Itoa(rec.status)).Inc()
+	return true
+}
+
+// serve writes the file matching the request path, if there is one
+func (f *staticFiles) serve(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	name := path.Clean("/" + r.URL.Path)
+	if !servable(name) {
+		return false
+	}
+	filename := filepath.Join(f.root, filepath.FromSlash(name))
+
+	info, err := os.Stat(filename)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+
+	// Content-Type always comes from the original name, not the variant
+	ctype := mime.TypeByExtension(filepath.Ext(filename))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+
+	file, encoding, err := f.variant(filename, info, r.Header.Get("Accept-Encoding"))
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return false
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", ctype)
+	h.Set("ETag", etag(fileInfo, encoding))
+	h.Add("Vary", "Accept-Encoding")
+	if encoding != "" {
+		h.Set("Content-Encoding", encoding)
+	}
+	if f.cacheControl != "" {
+		h.Set("Cache-Control", f.cacheControl)
+	}
+
+	// ServeContent handles Range, If-None-Match and If-Modified-Since
+	http.ServeContent(w, r, name, fileInfo.ModTime(), file)
+	return true
+}
+
+// variant opens the best precompressed sibling the client accepts, falling
+// back to the file itself
+func (f *staticFiles) variant(filename string, info os.FileInfo, acceptEncoding string) (*os.File, string, error) {
+	for _, pc := range precompressed {
+		if !acceptsEncoding(acceptEncoding, pc.encoding) {
+			continue
+		}
+		// Skip stale variants left behind by an older build
+		if vi, err := os.Stat(filename + pc.ext); err != nil || vi.ModTime().Before(info.ModTime()) {
+			continue
+		}
+		if file, err := os.Open(filename + pc.ext); err == nil {
+			return file, pc.encoding, nil
+		}
+	}
+
+	file, err := os.Open(filename)
+	return file, "", err
+}
+
+// servable rejects PHP sources and hidden files (.env, .git/...), which
+// must never be sent as plain files. The /.well-known/ directory (RFC
+// 8615, e.g. ACME challenges and security.txt) is public.
+func servable(name string) bool {
+	if strings.EqualFold(path.Ext(name), ".php") {
+		return false
+	}
+	for i, part := range strings.Split(strings.TrimPrefix(name, "/"), "/") {
+		if i == 0 && part == ".well-known" {
+			continue
+		}
+		if strings.HasPrefix(part, ".") {
+			return false
+		}
+	}
+	return true
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header allows enc
+func acceptsEncoding(header, enc string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), enc) {
+			continue
+		}
+		q, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !ok {
+			return true
+		}
+		v, err := strconv.ParseFloat(q, 64)
+		return err == nil && v > 0
+	}
+	return false
+}
+
+// etag derives a validator from modification time and size, like nginx.
+// Each encoding gets its own tag since the bytes differ.
+func etag(info os.FileInfo, encoding string) string {
+	tag := fmt.Sprintf("%x-%x", info.ModTime().Unix(), info.Size())
+	if encoding != "" {
+		tag += "-" + encoding
+	}
+	return `"` + tag + `"`
+}
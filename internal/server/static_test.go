@@ -0,0 +1,155 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tusk-framework/tusk-engine/internal/config"
+)
+
+func newTestStatic(t *testing.T) *staticFiles {
+	t.Helper()
+
+	root := t.TempDir()
+	// Same mtime for all files so no precompressed variant looks stale
+	mtime := time.Now().Add(-time.Minute)
+	files := map[string]string{
+		"app.css":        "body { color: black; }",
+		"app.css.br":     "brotli",
+		"app.js":         "console.log('hi');",
+		"app.js.gz":      "gzip",
+		"index.php":      "<?php echo 'secret';",
+		".env":           "DB_PASSWORD=secret",
+		"assets/log.txt": "0123456789",
+
+		".well-known/security.txt":                 "Contact: mailto:security@example.com",
+		".well-known/acme-challenge/token":         "token.thumbprint",
+		".well-known/.htpasswd":                    "admin:secret",
+		"assets/.well-known/security.txt":          "nested",
+		".well-known/acme-challenge/.hidden/token": "hidden",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.PublicDir = root
+	return newStaticFiles(cfg)
+}
+
+func serveStatic(f *staticFiles, method, target string, header map[string]string) (*httptest.ResponseRecorder, bool) {
+	req := httptest.NewRequest(method, target, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	return rec, f.serve(rec, req)
+}
+
+func TestStaticServesFiles(t *testing.T) {
+	f := newTestStatic(t)
+
+	rec, ok := serveStatic(f, http.MethodGet, "/assets/log.txt", nil)
+	if !ok || rec.Code != http.StatusOK {
+		t.Fatalf("Expected file to be served, got ok=%v status=%d", ok, rec.Code)
+	}
+	if rec.Body.String() != "0123456789" {
+		t.Errorf("Unexpected body %q", rec.Body.String())
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "public, max-age=3600" {
+		t.Errorf("Unexpected Cache-Control %q", got)
+	}
+	if rec.Header().Get("Last-Modified") == "" {
+		t.Error("Missing Last-Modified")
+	}
+
+	// Conditional request
+	tag := rec.Header().Get("ETag")
+	if tag == "" {
+		t.Fatal("Missing ETag")
+	}
+	rec, _ = serveStatic(f, http.MethodGet, "/assets/log.txt", map[string]string{"If-None-Match": tag})
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for matching ETag, got %d", rec.Code)
+	}
+
+	// Range request
+	rec, _ = serveStatic(f, http.MethodGet, "/assets/log.txt", map[string]string{"Range": "bytes=2-4"})
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "234" {
+		t.Errorf("Expected 206 with \"234\", got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestStaticPrecompressed(t *testing.T) {
+	f := newTestStatic(t)
+
+	tests := []struct {
+		target, accept       string
+		wantBody, wantCoding string
+	}{
+		{"/app.css", "gzip, br", "brotli", "br"},
+		{"/app.css", "gzip", "body { color: black; }", ""},
+		{"/app.js", "gzip, br", "gzip", "gzip"},
+		{"/app.js", "gzip;q=0", "console.log('hi');", ""},
+	}
+
+	for _, tt := range tests {
+		rec, ok := serveStatic(f, http.MethodGet, tt.target, map[string]string{"Accept-Encoding": tt.accept})
+		if !ok {
+			t.Fatalf("%s was not served", tt.target)
+		}
+		if rec.Body.String() != tt.wantBody || rec.Header().Get("Content-Encoding") != tt.wantCoding {
+			t.Errorf("%s with %q: got %q encoded %q", tt.target, tt.accept, rec.Body.String(), rec.Header().Get("Content-Encoding"))
+		}
+		if ct := rec.Header().Get("Content-Type"); ct == "" || ct == "application/octet-stream" {
+			t.Errorf("%s: Content-Type %q should come from the original extension", tt.target, ct)
+		}
+	}
+}
+
+func TestStaticWellKnown(t *testing.T) {
+	f := newTestStatic(t)
+
+	for target, want := range map[string]string{
+		"/.well-known/security.txt":         "Contact: mailto:security@example.com",
+		"/.well-known/acme-challenge/token": "token.thumbprint",
+	} {
+		rec, ok := serveStatic(f, http.MethodGet, target, nil)
+		if !ok || rec.Body.String() != want {
+			t.Errorf("%s: expected %q to be served, got %v %q", target, want, ok, rec.Body.String())
+		}
+	}
+
+	// Only the top-level directory is public, and dotfiles inside it are not
+	for _, target := range []string{"/.well-known/.htpasswd", "/assets/.well-known/security.txt", "/.well-known/acme-challenge/.hidden/token"} {
+		if _, ok := serveStatic(f, http.MethodGet, target, nil); ok {
+			t.Errorf("%s should fall through to PHP", target)
+		}
+	}
+}
+
+func TestStaticFallsThrough(t *testing.T) {
+	f := newTestStatic(t)
+
+	for _, target := range []string{"/", "/missing.css", "/index.php", "/.env", "/assets", "/../.env"} {
+		if _, ok := serveStatic(f, http.MethodGet, target, nil); ok {
+			t.Errorf("%s should fall through to PHP", target)
+		}
+	}
+	if _, ok := serveStatic(f, http.MethodPost, "/app.css", nil); ok {
+		t.Error("POST should fall through to PHP")
+	}
+}
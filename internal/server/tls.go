@@ -0,0 +1,168 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tusk-framework/tusk-engine/internal/config"
+)
+
+// certCheckInterval is how often certificate files are checked for changes
+const certCheckInterval = 10 * time.Second
+
+// keyPair is a certificate loaded from disk along with the file versions
+// it was loaded from
+type keyPair struct {
+	certFile, keyFile string
+	cert              *tls.Certificate
+	modTime           time.Time
+}
+
+// certStore holds the TLS certificates and reloads them when their files
+// change, so rotated certificates (e.g. from cert-manager) are picked up
+// without a restart
+type certStore struct {
+	mu    sync.RWMutex
+	pairs []*keyPair
+	stop  chan struct{}
+}
+
+// newCertStore loads tls_cert/tls_key followed by tls_certificates
+func newCertStore(cfg *config.Config) (*certStore, error) {
+	var files []config.TLSCertificate
+	if cfg.TLSCert != "" || cfg.TLSKey != "" {
+		files = append(files, config.TLSCertificate{Cert: cfg.TLSCert, Key: cfg.TLSKey})
+	}
+	files = append(files, cfg.TLSCertificates...)
+
+	c := &certStore{stop: make(chan struct{})}
+	for _, f := range files {
+		pair := &keyPair{
+			certFile: resolvePath(cfg, f.Cert),
+			keyFile:  resolvePath(cfg, f.Key),
+		}
+		if err := pair.load(); err != nil {
+			return nil, err
+		}
+		c.pairs = append(c.pairs, pair)
+	}
+	if len(c.pairs) == 0 {
+		return nil, fmt.Errorf("no TLS certificates configured")
+	}
+	return c, nil
+}
+
+// tlsEnabled reports whether any certificate is configured
+func tlsEnabled(cfg *config.Config) bool {
+	return cfg.TLSCert != "" || len(cfg.TLSCertificates) > 0
+}
+
+// resolvePath makes a configured path relative to the project root
+func resolvePath(cfg *config.Config, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(cfg.ProjectRoot, path)
+}
+
+// load reads the key pair from disk
+func (k *keyPair) load() error {
+	cert, err := tls.LoadX509KeyPair(k.certFile, k.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate %s: %w", k.certFile, err)
+	}
+	k.cert = &cert
+	k.modTime = k.lastModified()
+	return nil
+}
+
+// lastModified returns the newest modification time of the two files
+func (k *keyPair) lastModified() time.Time {
+	var latest time.Time
+	for _, name := range []string{k.certFile, k.keyFile} {
+		if info, err := os.Stat(name); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// getCertificate picks the certificate matching the client's SNI name,
+// falling back to the first one
+func (c *certStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if hello.ServerName != "" {
+		for _, pair := range c.pairs {
+			if hello.SupportsCertificate(pair.cert) == nil {
+				return pair.cert, nil
+			}
+		}
+	}
+	return c.pairs[0].cert, nil
+}
+
+// reload re-reads certificates whose files changed. A pair that fails to
+// load (e.g. the key was written but not the certificate yet) keeps
+// serving the previous certificate until the next check.
+func (c *certStore) reload() {
+	for i, pair := range c.pairs {
+		if !pair.lastModified().After(pair.modTime) {
+			continue
+		}
+
+		next := &keyPair{certFile: pair.certFile, keyFile: pair.keyFile}
+		if err := next.load(); err != nil {
+			slog.Warn("Keeping previous TLS certificate", "err", err)
+			continue
+		}
+
+		c.mu.Lock()
+		c.pairs[i] = next
+		c.mu.Unlock()
+		slog.Info("Reloaded TLS certificate", "file", next.certFile)
+	}
+}
+
+// watch checks for changed certificate files until Close is called
+func (c *certStore) watch() {
+	ticker := time.NewTicker(certCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.reload()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// Close stops watching the certificate files
+func (c *certStore) Close() {
+	close(c.stop)
+}
+
+// redirectHandler sends plain HTTP requests to the HTTPS listener
+func redirectHandler(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
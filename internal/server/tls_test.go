@@ -0,0 +1,157 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tusk-framework/tusk-engine/internal/config"
+)
+
+// writeSelfSigned writes a self-signed certificate for host and returns the
+// certificate and key paths
+func writeSelfSigned(t *testing.T, dir, host string, serial int64) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, host+".crt")
+	keyFile := filepath.Join(dir, host+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// servedSerial performs a handshake for serverName and returns the serial
+// number of the certificate the server presented
+func servedSerial(t *testing.T, addr, serverName string) int64 {
+	t.Helper()
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("Handshake for %q failed: %v", serverName, err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+}
+
+func TestTLSCertificates(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.DefaultConfig()
+	cfg.TLSCert, cfg.TLSKey = writeSelfSigned(t, dir, "example.com", 1)
+	apiCert, apiKey := writeSelfSigned(t, dir, "api.example.com", 2)
+	cfg.TLSCertificates = []config.TLSCertificate{{Cert: apiCert, Key: apiKey}}
+
+	certs, err := newCertStore(cfg)
+	if err != nil {
+		t.Fatalf("Failed to load certificates: %v", err)
+	}
+	defer certs.Close()
+
+	srv := httptest.NewUnstartedServer(http.NotFoundHandler())
+	srv.TLS = &tls.Config{GetCertificate: certs.getCertificate}
+	srv.StartTLS()
+	defer srv.Close()
+	addr := srv.Listener.Addr().String()
+
+	// SNI selection, with the default certificate as fallback
+	if got := servedSerial(t, addr, "example.com"); got != 1 {
+		t.Errorf("example.com: expected certificate 1, got %d", got)
+	}
+	if got := servedSerial(t, addr, "api.example.com"); got != 2 {
+		t.Errorf("api.example.com: expected certificate 2, got %d", got)
+	}
+	if got := servedSerial(t, addr, "unknown.test"); got != 1 {
+		t.Errorf("unknown.test: expected default certificate 1, got %d", got)
+	}
+
+	// Rotate the default certificate on disk
+	writeSelfSigned(t, dir, "example.com", 3)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(cfg.TLSCert, future, future)
+	certs.reload()
+
+	if got := servedSerial(t, addr, "example.com"); got != 3 {
+		t.Errorf("Expected rotated certificate 3, got %d", got)
+	}
+}
+
+func TestTLSReloadKeepsCertificateOnError(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.DefaultConfig()
+	cfg.TLSCert, cfg.TLSKey = writeSelfSigned(t, dir, "example.com", 1)
+
+	certs, err := newCertStore(cfg)
+	if err != nil {
+		t.Fatalf("Failed to load certificates: %v", err)
+	}
+	defer certs.Close()
+
+	// Half-written rotation
+	os.WriteFile(cfg.TLSCert, []byte("garbage"), 0o600)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(cfg.TLSCert, future, future)
+	certs.reload()
+
+	cert, _ := certs.getCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+	if cert == nil || cert.Leaf.SerialNumber.Int64() != 1 {
+		t.Error("Expected the previous certificate to keep serving")
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct {
+		port       int
+		host, want string
+	}{
+		{443, "example.com", "https://example.com/path?q=1"},
+		{443, "example.com:80", "https://example.com/path?q=1"},
+		{8443, "example.com:8080", "https://example.com:8443/path?q=1"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/path?q=1", nil)
+		req.Host = tt.host
+		rec := httptest.NewRecorder()
+		redirectHandler(tt.port).ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != tt.want {
+			t.Errorf("Host %s: got %d %q, want %q", tt.host, rec.Code, rec.Header().Get("Location"), tt.want)
+		}
+	}
+}
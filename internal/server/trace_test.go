@@ -0,0 +1,57 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tusk-framework/tusk-engine/internal/config"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	cfg := config.DefaultConfig()
+	s := NewServer(cfg, newTestPool(t, cfg))
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	server, queue, work := spans["GET"], spans["worker.queue"], spans["worker.request"]
+	if server == nil || queue == nil || work == nil {
+		t.Fatalf("Expected GET, worker.queue and worker.request spans, got %v", spans)
+	}
+
+	if server.SpanContext().TraceID().String() != traceID || server.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("Server span did not continue the incoming trace")
+	}
+	for _, child := range []sdktrace.ReadOnlySpan{queue, work} {
+		if child.Parent().SpanID() != server.SpanContext().SpanID() {
+			t.Errorf("%s is not a child of the server span", child.Name())
+		}
+	}
+
+	// The worker receives the worker.request span as its parent
+	traceparent := resp.Header.Get("X-Traceparent")
+	want := "00-" + traceID + "-" + work.SpanContext().SpanID().String()
+	if !strings.HasPrefix(traceparent, want) {
+		t.Errorf("Worker got traceparent %q, want prefix %q", traceparent, want)
+	}
+}
@@ -0,0 +1,389 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/tusk-framework/tusk-engine/internal/metrics"
+	"github.com/tusk-framework/tusk-engine/internal/worker"
+)
+
+// WebSocket events dispatched to workers. Each one is an ordinary request
+// envelope with a "type" and the "connection" ID; the response may carry
+// "ws" actions.
+const (
+	wsOpen    = "ws.open"
+	wsMessage = "ws.message"
+	wsClose   = "ws.close"
+)
+
+const (
+	wsSendBuffer     = 256 // queued outgoing messages before a slow client is dropped
+	wsWriteTimeout   = 10 * time.Second
+	wsMaxMessageSize = 1024 * 1024 // when websocket_max_message_size is not set
+)
+
+// wsConn is an upgraded connection owned by the server
+type wsConn struct {
+	id       string
+	conn     *websocket.Conn
+	send     chan wsFrame
+	channels map[string]bool // guarded by wsHub.mu
+	done     chan struct{}
+	once     sync.Once
+}
+
+// wsFrame is a message queued for a connection's writer
+type wsFrame struct {
+	messageType int
+	data        []byte
+}
+
+// wsHub tracks open connections and the channels they joined
+type wsHub struct {
+	mu       sync.RWMutex
+	conns    map[string]*wsConn
+	channels map[string]map[string]*wsConn
+	closed   bool           // set by shutdown; later connections are refused
+	handlers sync.WaitGroup // connections whose ws.close is not dispatched yet
+}
+
+func newWSHub() *wsHub {
+	return &wsHub{
+		conns:    make(map[string]*wsConn),
+		channels: make(map[string]map[string]*wsConn),
+	}
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+}
+
+// isWebSocketRoute reports whether upgrades are accepted on urlPath
+func (s *Server) isWebSocketRoute(urlPath string) bool {
+	for _, route := range s.cfg.WebSocketRoutes {
+		if ok, _ := path.Match(route, urlPath); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// handleWebSocket asks a worker to accept the connection (ws.open), then
+// keeps the socket in Go and dispatches every message to a worker
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	id := newConnectionID()
+
+	// The worker may reject the upgrade, e.g. for failed authentication
+	req := requestEnvelope(r)
+	req.Type = wsOpen
+	req.Connection = id
+	resp, err := s.dispatchEvent(req)
+	if err != nil {
+		slog.Error("WebSocket open failed", "uri", r.RequestURI, "request_id", requestID(r.Context()), "err", err)
+		http.Error(w, fmt.Sprintf("Engine Error: %v", err), http.StatusBadGateway)
+		return
+	}
+	if resp.Status >= 300 {
+		http.Error(w, http.StatusText(resp.Status), resp.Status)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // Upgrade already replied with an error
+	}
+
+	c := &wsConn{
+		id:       id,
+		conn:     conn,
+		send:     make(chan wsFrame, wsSendBuffer),
+		channels: make(map[string]bool),
+		done:     make(chan struct{}),
+	}
+	limit := s.cfg.WebSocketMaxMessageSize
+	if limit <= 0 {
+		limit = wsMaxMessageSize
+	}
+	conn.SetReadLimit(limit)
+
+	if s.hub.add(c) {
+		defer s.hub.handlers.Done()
+	} else {
+		c.close(websocket.CloseGoingAway)
+	}
+	go c.writeLoop()
+
+	s.hub.apply(resp.WS, c)
+
+	code := websocket.CloseNormalClosure
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			var ce *websocket.CloseError
+			switch {
+			case errors.As(err, &ce):
+				code = ce.Code
+			case errors.Is(err, websocket.ErrReadLimit):
+				code = websocket.CloseMessageTooBig
+			default:
+				// The client vanished without a close frame
+				code = websocket.CloseGoingAway
+			}
+			break
+		}
+
+		event := &worker.Request{
+			Type:       wsMessage,
+			Connection: id,
+			Data:       string(data),
+			Binary:     messageType == websocket.BinaryMessage,
+		}
+		// JSON strings would mangle binary messages
+		if event.Binary && s.cfg.Protocol != worker.ProtocolMsgpack {
+			event.Data = base64.StdEncoding.EncodeToString(data)
+			event.Encoding = worker.EncodingBase64
+		}
+		resp, err := s.dispatchEvent(event)
+		if err != nil {
+			slog.Error("WebSocket message failed", "connection", id, "err", err)
+			c.close(websocket.CloseInternalServerErr)
+			break
+		}
+		s.hub.apply(resp.WS, c)
+	}
+
+	s.hub.remove(c)
+	c.close(code)
+	if resp, err := s.dispatchEvent(&worker.Request{
+		Type:       wsClose,
+		Connection: id,
+		Code:       code,
+	}); err == nil {
+		s.hub.apply(resp.WS, nil)
+	}
+}
+
+// dispatchEvent sends a WebSocket event to a worker
+func (s *Server) dispatchEvent(req *worker.Request) (*worker.Response, error) {
+	resp, err := s.pool.HandleRequest(req, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Events have no HTTP body; release a streamed one right away
+	if resp.Stream != nil {
+		resp.Stream.Close()
+	}
+	return resp, nil
+}
+
+// newConnectionID returns a random connection identifier
+func newConnectionID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// add registers a connection, reporting false once the hub is shut down.
+// The handler of an added connection calls h.handlers.Done after its
+// ws.close event.
+func (h *wsHub) add(c *wsConn) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return false
+	}
+	h.conns[c.id] = c
+	h.handlers.Add(1)
+	metrics.WebSocketConnections.Inc()
+	return true
+}
+
+// remove drops a connection and its channel memberships; it is safe to
+// call more than once
+func (h *wsHub) remove(c *wsConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.conns[c.id]; !ok {
+		return
+	}
+	delete(h.conns, c.id)
+	for channel := range c.channels {
+		h.leave(c, channel)
+	}
+	metrics.WebSocketConnections.Dec()
+}
+
+// shutdown closes every connection as going away and waits until their
+// ws.close events have been dispatched, or ctx is done
+func (h *wsHub) shutdown(ctx context.Context) {
+	h.mu.Lock()
+	h.closed = true
+	conns := make([]*wsConn, 0, len(h.conns))
+	for _, c := range h.conns {
+		conns = append(conns, c)
+	}
+	h.mu.Unlock()
+
+	for _, c := range conns {
+		c.close(websocket.CloseGoingAway)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		h.handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// leave removes c from a channel; h.mu must be held
+func (h *wsHub) leave(c *wsConn, channel string) {
+	delete(c.channels, channel)
+	if members := h.channels[channel]; members != nil {
+		delete(members, c.id)
+		if len(members) == 0 {
+			delete(h.channels, channel)
+		}
+	}
+}
+
+// apply runs the "ws" actions from a worker response. Actions default to
+// the connection the event came from (from is nil for plain HTTP requests
+// and close events, so those must name a connection or channel):
+//
+//	{"action": "send", "data": "...", "connection": "<id>", "binary": false}
+//	{"action": "broadcast", "channel": "room", "data": "..."}
+//	{"action": "join" | "leave", "channel": "room", "connection": "<id>"}
+//	{"action": "close", "connection": "<id>", "code": 1000}
+func (h *wsHub) apply(actions []worker.WSAction, from *wsConn) {
+	for _, action := range actions {
+		target := from
+		if action.Connection != "" {
+			h.mu.RLock()
+			target = h.conns[action.Connection]
+			h.mu.RUnlock()
+		}
+		channel := action.Channel
+
+		switch action.Action {
+		case "send":
+			if target != nil {
+				target.queue(frameFor(action))
+			}
+		case "broadcast":
+			frame := frameFor(action)
+			h.mu.RLock()
+			for _, c := range h.channels[channel] {
+				c.queue(frame)
+			}
+			h.mu.RUnlock()
+		case "join":
+			if target != nil && channel != "" {
+				h.mu.Lock()
+				if _, open := h.conns[target.id]; open {
+					target.channels[channel] = true
+					if h.channels[channel] == nil {
+						h.channels[channel] = make(map[string]*wsConn)
+					}
+					h.channels[channel][target.id] = target
+				}
+				h.mu.Unlock()
+			}
+		case "leave":
+			if target != nil {
+				h.mu.Lock()
+				h.leave(target, channel)
+				h.mu.Unlock()
+			}
+		case "close":
+			if target != nil {
+				code := action.Code
+				if !sendableCloseCode(code) {
+					if code != 0 {
+						slog.Warn("Invalid WebSocket close code, using 1000", "connection", target.id, "code", code)
+					}
+					code = websocket.CloseNormalClosure
+				}
+				target.close(code)
+			}
+		default:
+			slog.Warn("Unknown WebSocket action", "action", action.Action)
+		}
+	}
+}
+
+// sendableCloseCode reports whether code may be sent in a close frame (RFC
+// 6455 section 7.4). 1005, 1006 and 1015 only describe a closure locally.
+func sendableCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// frameFor builds the message described by a send or broadcast action
+func frameFor(action worker.WSAction) wsFrame {
+	frame := wsFrame{messageType: websocket.TextMessage, data: action.Data}
+	if action.Binary {
+		frame.messageType = websocket.BinaryMessage
+	}
+	return frame
+}
+
+// queue hands a message to the writer, dropping clients that cannot keep up
+func (c *wsConn) queue(frame wsFrame) {
+	select {
+	case <-c.done:
+	case c.send <- frame:
+	default:
+		slog.Warn("WebSocket client is not reading, closing it", "connection", c.id)
+		c.close(websocket.ClosePolicyViolation)
+	}
+}
+
+// writeLoop is the connection's only writer, as gorilla/websocket requires
+func (c *wsConn) writeLoop() {
+	for {
+		select {
+		case frame := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := c.conn.WriteMessage(frame.messageType, frame.data); err != nil {
+				c.close(websocket.CloseInternalServerErr)
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// close sends a close frame and tears down the connection, which also ends
+// the read loop in handleWebSocket
+func (c *wsConn) close(code int) {
+	c.once.Do(func() {
+		close(c.done)
+		msg := websocket.FormatCloseMessage(code, "")
+		c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		c.conn.Close()
+	})
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/tusk-framework/tusk-engine/internal/config"
+)
+
+func dialWS(t *testing.T, ts *httptest.Server, path string) *websocket.Conn {
+	t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+path, nil)
+	if err != nil {
+		t.Fatalf("Dial %s failed: %v", path, err)
+	}
+	return conn
+}
+
+func expectMessage(t *testing.T, conn *websocket.Conn, want string) {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Expected %q, got error: %v", want, err)
+	}
+	if string(data) != want {
+		t.Fatalf("Expected %q, got %q", want, data)
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.WebSocketRoutes = []string{"/ws/*"}
+	s := NewServer(cfg, newTestPool(t, cfg))
+
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	alice := dialWS(t, ts, "/ws/alice")
+	defer alice.Close()
+	bob := dialWS(t, ts, "/ws/bob")
+	defer bob.Close()
+
+	// Messages are dispatched to the worker, which replies to the sender
+	alice.WriteMessage(websocket.TextMessage, []byte("hello"))
+	expectMessage(t, alice, "echo:hello")
+	bob.WriteMessage(websocket.TextMessage, []byte("hello"))
+	expectMessage(t, bob, "echo:hello")
+
+	// Both joined the lobby channel on open
+	alice.WriteMessage(websocket.TextMessage, []byte("broadcast:hi all"))
+	expectMessage(t, alice, "hi all")
+	expectMessage(t, bob, "hi all")
+
+	// The close event is dispatched too
+	bob.Close()
+	expectMessage(t, alice, "left")
+
+	s.hub.mu.RLock()
+	conns, members := len(s.hub.conns), len(s.hub.channels["lobby"])
+	s.hub.mu.RUnlock()
+	if conns != 1 || members != 1 {
+		t.Errorf("Expected 1 connection in the lobby, got %d connections and %d members", conns, members)
+	}
+}
+
+func TestWebSocketBinary(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.WebSocketRoutes = []string{"/ws/*"}
+	s := NewServer(cfg, newTestPool(t, cfg))
+
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	conn := dialWS(t, ts, "/ws/alice")
+	defer conn.Close()
+
+	// Invalid UTF-8 survives the trip through an ndjson worker
+	payload := []byte{0x00, 0xff, 0xfe, 0x80, '\n', 0x7f}
+	conn.WriteMessage(websocket.BinaryMessage, payload)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	messageType, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Expected the binary message back, got error: %v", err)
+	}
+	if messageType != websocket.BinaryMessage || !bytes.Equal(data, payload) {
+		t.Errorf("Binary message mangled: got type %d %v, want %v", messageType, data, payload)
+	}
+}
+
+func TestWebSocketCloseCode(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.WebSocketRoutes = []string{"/ws/*"}
+	s := NewServer(cfg, newTestPool(t, cfg))
+
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	tests := []struct {
+		code string
+		want int
+	}{
+		{"4001", 4001},
+		{"1006", websocket.CloseNormalClosure}, // may not be sent
+		{"5000", websocket.CloseNormalClosure},
+	}
+	for _, tt := range tests {
+		conn := dialWS(t, ts, "/ws/alice")
+		conn.WriteMessage(websocket.TextMessage, []byte("close:"+tt.code))
+		expectClose(t, conn, tt.want)
+		conn.Close()
+	}
+}
+
+func TestWebSocketRejected(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.WebSocketRoutes = []string{"/ws/*"}
+	s := NewServer(cfg, newTestPool(t, cfg))
+
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+	_, resp, err := websocket.DefaultDialer.Dial(url+"/ws/deny", nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected the worker to reject the upgrade with 403, got %v", err)
+	}
+
+	// Routes that are not configured are plain HTTP requests
+	if s.isWebSocketRoute("/chat") {
+		t.Error("/chat should not accept WebSocket upgrades")
+	}
+}
+
+func expectClose(t *testing.T, conn *websocket.Conn, want int) {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := conn.ReadMessage()
+	var ce *websocket.CloseError
+	if !errors.As(err, &ce) || ce.Code != want {
+		t.Fatalf("Expected close code %d, got %v", want, err)
+	}
+}
+
+func TestWebSocketReadLimit(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.WebSocketRoutes = []string{"/ws/*"}
+	cfg.WebSocketMaxMessageSize = 16
+	s := NewServer(cfg, newTestPool(t, cfg))
+
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	conn := dialWS(t, ts, "/ws/alice")
+	defer conn.Close()
+
+	conn.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("x", 64)))
+	expectClose(t, conn, websocket.CloseMessageTooBig)
+}
+
+func TestWebSocketStop(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.WebSocketRoutes = []string{"/ws/*"}
+	s := NewServer(cfg, newTestPool(t, cfg))
+
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	conn := dialWS(t, ts, "/ws/alice")
+	defer conn.Close()
+	conn.WriteMessage(websocket.TextMessage, []byte("hello"))
+	expectMessage(t, conn, "echo:hello")
+
+	// Upgraded connections are closed as going away on shutdown
+	s.Stop(context.Background())
+	expectClose(t, conn, websocket.CloseGoingAway)
+}
@@ -0,0 +1,69 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/tusk-framework/tusk-engine/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Propagator reads and writes W3C traceparent/tracestate. It is used even
+// when export is off, so PHP can still continue an incoming trace.
+var Propagator = propagation.TraceContext{}
+
+// Tracer returns the named tracer from the global provider
+func Tracer(name string) trace.Tracer {
+	return otel.Tracer(name)
+}
+
+// Setup installs a tracer provider that exports spans over OTLP/HTTP to
+// otlp_endpoint, keeping trace_sample_ratio of new traces (0 keeps none).
+// The returned function flushes and stops the exporter.
+func Setup(ctx context.Context, cfg *config.Config) (func(context.Context) error, error) {
+	ratio := cfg.TraceSampleRatio
+	if ratio < 0 || ratio > 1 {
+		return nil, fmt.Errorf("invalid trace_sample_ratio %v: must be between 0 and 1", ratio)
+	}
+
+	endpoint, err := url.Parse(cfg.OTLPEndpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid otlp_endpoint %q", cfg.OTLPEndpoint)
+	}
+	if endpoint.Path == "" || endpoint.Path == "/" {
+		endpoint.Path = "/v1/traces"
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint.String()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	name := cfg.TraceServiceName
+	if name == "" {
+		name = "tusk"
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(name))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(Propagator)
+	return provider.Shutdown, nil
+}
+
+// Inject returns the span context of ctx as W3C traceparent and tracestate
+// values for a worker request envelope
+func Inject(ctx context.Context) (traceparent, tracestate string) {
+	carrier := propagation.MapCarrier{}
+	Propagator.Inject(ctx, carrier)
+	return carrier.Get("traceparent"), carrier.Get("tracestate")
+}
@@ -0,0 +1,94 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/tusk-framework/tusk-engine/internal/config"
+	"go.opentelemetry.io/otel/propagation"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestSetupExportsSpans(t *testing.T) {
+	// Stub OTLP/HTTP collector
+	var (
+		mu    sync.Mutex
+		path  string
+		names []string
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		var req collectortrace.ExportTraceServiceRequest
+		if err := proto.Unmarshal(data, &req); err != nil {
+			t.Errorf("Invalid export request: %v", err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		path = r.URL.Path
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, span := range ss.Spans {
+					names = append(names, span.Name)
+				}
+			}
+		}
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}))
+	defer collector.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.OTLPEndpoint = collector.URL
+	shutdown, err := Setup(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	_, span := Tracer("test").Start(context.Background(), "test.span")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if path != "/v1/traces" {
+		t.Errorf("Expected export to /v1/traces, got %q", path)
+	}
+	if len(names) != 1 || names[0] != "test.span" {
+		t.Errorf("Expected test.span to be exported, got %v", names)
+	}
+}
+
+func TestSetupInvalidEndpoint(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.OTLPEndpoint = "localhost:4318"
+	if _, err := Setup(context.Background(), cfg); err == nil {
+		t.Error("Expected an error for an endpoint without a scheme")
+	}
+}
+
+func TestSetupInvalidSampleRatio(t *testing.T) {
+	for _, ratio := range []float64{-0.5, 1.5} {
+		cfg := config.DefaultConfig()
+		cfg.OTLPEndpoint = "http://localhost:4318"
+		cfg.TraceSampleRatio = ratio
+		if _, err := Setup(context.Background(), cfg); err == nil {
+			t.Errorf("Expected an error for trace_sample_ratio %v", ratio)
+		}
+	}
+}
+
+func TestInject(t *testing.T) {
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	ctx := Propagator.Extract(context.Background(), propagation.MapCarrier{"traceparent": traceparent})
+
+	if got, _ := Inject(ctx); got != traceparent {
+		t.Errorf("Expected traceparent %q, got %v", traceparent, got)
+	}
+}
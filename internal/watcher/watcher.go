@@ -0,0 +1,172 @@
+package watcher
+
+import (
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// DefaultDebounce is how long the watcher waits for a burst of file saves
+// to settle before reporting changes
+const DefaultDebounce = 300 * time.Millisecond
+
+// Watcher reports changes to files under a root directory that match the
+// include globs and none of the exclude globs
+type Watcher struct {
+	root     string
+	include  []string
+	exclude  []string
+	debounce time.Duration
+	fsw      *fsnotify.Watcher
+}
+
+// New creates a watcher for root. Globs without a slash match file or
+// directory names at any depth ("*.php"), globs with a slash match the path
+// relative to root ("config/*.yaml"), and a trailing slash only matches
+// directories ("vendor/").
+func New(root string, include, exclude []string) (*Watcher, error) {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Watcher{
+		root:     root,
+		include:  include,
+		exclude:  exclude,
+		debounce: DefaultDebounce,
+		fsw:      fsw,
+	}
+	if err := w.addTree(root); err != nil {
+		fsw.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Run delivers debounced batches of changed files (relative to root) to
+// onChange until Close is called
+func (w *Watcher) Run(onChange func(files []string)) {
+	pending := make(map[string]bool)
+	timer := time.NewTimer(w.debounce)
+	timer.Stop()
+
+	for {
+		select {
+		case event, ok := <-w.fsw.Events:
+			if !ok {
+				return
+			}
+			if rel, ok := w.handle(event); ok {
+				pending[rel] = true
+				timer.Reset(w.debounce)
+			}
+		case err, ok := <-w.fsw.Errors:
+			if !ok {
+				return
+			}
+			slog.Warn("File watcher error", "err", err)
+		case <-timer.C:
+			files := make([]string, 0, len(pending))
+			for f := range pending {
+				files = append(files, f)
+			}
+			sort.Strings(files)
+			pending = make(map[string]bool)
+			onChange(files)
+		}
+	}
+}
+
+// Close stops watching
+func (w *Watcher) Close() error {
+	return w.fsw.Close()
+}
+
+// handle processes a raw event, returning the relative path of a relevant change
+func (w *Watcher) handle(event fsnotify.Event) (string, bool) {
+	if event.Op == fsnotify.Chmod {
+		return "", false
+	}
+
+	rel, err := filepath.Rel(w.root, event.Name)
+	if err != nil {
+		return "", false
+	}
+
+	// inotify is not recursive, so follow newly created directories
+	if event.Op.Has(fsnotify.Create) {
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			if !w.excluded(rel, true) {
+				w.addTree(event.Name)
+			}
+			return "", false
+		}
+	}
+
+	if w.excluded(rel, false) || !matchAny(w.include, rel, false) {
+		return "", false
+	}
+	return rel, true
+}
+
+// addTree watches dir and all of its subdirectories that are not excluded.
+// Hidden directories (.git, .idea, ...) are skipped as well: they can hold
+// enough entries to exhaust the inotify watch limit and change on every
+// commit or IDE save.
+func (w *Watcher) addTree(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // Skip unreadable entries
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if rel, _ := filepath.Rel(w.root, path); rel != "." && (strings.HasPrefix(d.Name(), ".") || w.excluded(rel, true)) {
+			return filepath.SkipDir
+		}
+		return w.fsw.Add(path)
+	})
+}
+
+// excluded reports whether rel or any of its parent directories is excluded
+func (w *Watcher) excluded(rel string, isDir bool) bool {
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for i := range parts {
+		dirPart := i < len(parts)-1 || isDir
+		if matchAny(w.exclude, strings.Join(parts[:i+1], "/"), dirPart) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAny reports whether rel matches any of the globs
+func matchAny(globs []string, rel string, isDir bool) bool {
+	rel = filepath.ToSlash(rel)
+	for _, glob := range globs {
+		pattern := glob
+		if strings.HasSuffix(pattern, "/") {
+			if !isDir {
+				continue
+			}
+			pattern = strings.TrimSuffix(pattern, "/")
+		}
+
+		target := rel
+		if !strings.Contains(pattern, "/") {
+			target = rel[strings.LastIndex(rel, "/")+1:]
+		}
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMatchAny(t *testing.T) {
+	tests := []struct {
+		globs []string
+		rel   string
+		isDir bool
+		want  bool
+	}{
+		{[]string{"*.php"}, "index.php", false, true},
+		{[]string{"*.php"}, "src/Controller/Home.php", false, true},
+		{[]string{"*.php"}, "README.md", false, false},
+		{[]string{"config/*.yaml"}, "config/app.yaml", false, true},
+		{[]string{"config/*.yaml"}, "src/config/app.yaml", false, false},
+		{[]string{"vendor/"}, "vendor", true, true},
+		{[]string{"vendor/"}, "vendor", false, false},
+		{[]string{"*.php"}, ".env", false, false},
+		{[]string{"*.php", ".env"}, ".env", false, true},
+	}
+
+	for _, tt := range tests {
+		if got := matchAny(tt.globs, tt.rel, tt.isDir); got != tt.want {
+			t.Errorf("matchAny(%v, %q, %v) = %v, want %v", tt.globs, tt.rel, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestExcluded(t *testing.T) {
+	w := &Watcher{exclude: []string{"vendor/", "var/cache/"}}
+
+	tests := []struct {
+		rel   string
+		isDir bool
+		want  bool
+	}{
+		{"vendor", true, true},
+		{"vendor/autoload.php", false, true},
+		{"src/vendor/Lib.php", false, true},
+		{"var/cache/routes.php", false, true},
+		{"var/log.php", false, false},
+		{"src/Kernel.php", false, false},
+	}
+
+	for _, tt := range tests {
+		if got := w.excluded(tt.rel, tt.isDir); got != tt.want {
+			t.Errorf("excluded(%q) = %v, want %v", tt.rel, got, tt.want)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"src", "vendor", ".git"} {
+		if err := os.Mkdir(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w, err := New(root, []string{"*.php"}, []string{"vendor/"})
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	defer w.Close()
+	w.debounce = 50 * time.Millisecond
+
+	changes := make(chan []string, 1)
+	go w.Run(func(files []string) { changes <- files })
+
+	write := func(rel string) {
+		if err := os.WriteFile(filepath.Join(root, rel), []byte("<?php\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("vendor/autoload.php")
+	write(".git/hook.php")
+	write("src/notes.txt")
+	write("src/Kernel.php")
+	write("index.php")
+
+	select {
+	case files := <-changes:
+		want := []string{"index.php", filepath.Join("src", "Kernel.php")}
+		if len(files) != len(want) || files[0] != want[0] || files[1] != want[1] {
+			t.Errorf("Expected changes %v, got %v", want, files)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("No change reported")
+	}
+}
@@ -0,0 +1,106 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/tusk-framework/tusk-engine/internal/metrics"
+)
+
+// frameCancel is sent to a worker whose client went away while it was
+// serving the request. Workers announcing capabilityCancel in their ready
+// message stop and answer with frameCancelled; a cancel frame that arrives
+// after the response was sent must be ignored.
+const (
+	frameCancel      = "cancel"
+	frameCancelled   = "cancelled"
+	capabilityCancel = "cancel"
+)
+
+// Stages at which a request was cancelled (the "stage" label of
+// tusk_requests_cancelled_total)
+const (
+	cancelQueue  = "queue"
+	cancelWorker = "worker"
+)
+
+// canceller forwards a client's cancellation to the worker serving its
+// request. Workers that do not answer within cancel_grace are killed, and
+// watchWorker replaces them. Workers without capabilityCancel cannot be
+// told to stop, so they get cancel_grace to finish the request, whose
+// response is discarded.
+type canceller struct {
+	w     *Process
+	grace time.Duration
+	stop  func() bool
+	done  chan struct{}
+	timer *time.Timer
+
+	expired atomic.Bool // the worker was killed after cancel_grace
+}
+
+// watchCancel starts forwarding ctx's cancellation to w. It must only be
+// called once the request has been sent, as the cancel frame is written
+// concurrently with reading the response.
+func (p *Pool) watchCancel(ctx context.Context, w *Process) *canceller {
+	grace := time.Duration(p.cfg.CancelGrace)
+	if grace < 0 {
+		grace = 0
+	}
+	c := &canceller{w: w, grace: grace, done: make(chan struct{})}
+	c.stop = context.AfterFunc(ctx, c.cancel)
+	return c
+}
+
+func (c *canceller) cancel() {
+	defer close(c.done)
+	metrics.RequestsCancelled.WithLabelValues(cancelWorker).Inc()
+
+	c.timer = time.AfterFunc(c.grace, func() {
+		c.expired.Store(true)
+		c.w.kill()
+	})
+	if !c.w.hasCapability(capabilityCancel) {
+		return
+	}
+	if err := c.w.Codec.Encode(map[string]interface{}{"type": frameCancel}); err != nil {
+		c.w.kill()
+	}
+}
+
+// release stops forwarding and reports whether the request was cancelled.
+// A cancellation in progress is waited for, so its frame is written before
+// the worker can be handed to another request.
+func (c *canceller) release() bool {
+	if c.stop() {
+		return false
+	}
+	<-c.done
+	// A grace timer that already fired may still kill the worker, so kill
+	// it now rather than after it is handed to another request
+	if c.timer != nil && !c.timer.Stop() {
+		c.expired.Store(true)
+		c.w.kill()
+	}
+	return true
+}
+
+// cancelled returns the error for a request whose client went away while
+// w was serving it
+func (c *canceller) cancelled(ctx context.Context) error {
+	if c.expired.Load() {
+		slog.Warn("Worker did not stop a cancelled request in time, replacing", "worker", c.w.ID, "grace", c.grace)
+		return fmt.Errorf("worker %d killed %s after cancellation: %w", c.w.ID, c.grace, ctx.Err())
+	}
+	return fmt.Errorf("worker %d: %w", c.w.ID, ctx.Err())
+}
+
+// hasCapability reports whether the worker announced capability c in its
+// ready message
+func (w *Process) hasCapability(c string) bool {
+	return slices.Contains(w.Capabilities, c)
+}
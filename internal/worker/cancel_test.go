@@ -0,0 +1,171 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tusk-framework/tusk-engine/internal/config"
+)
+
+// cancelConfig gives cancelled requests a short grace
+func cancelConfig(handshake bool) func(*config.Config) {
+	return func(cfg *config.Config) {
+		cfg.WorkerHandshake = handshake
+		cfg.CancelGrace = config.Duration(100 * time.Millisecond)
+		cfg.RestartBackoff = config.Duration(20 * time.Millisecond)
+	}
+}
+
+// cancelAfter sends req, cancels it after d and returns how long the
+// request took
+func cancelAfter(pool *Pool, req *Request, d time.Duration) (time.Duration, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	time.AfterFunc(d, cancel)
+
+	start := time.Now()
+	_, err := pool.HandleRequestContext(ctx, req, nil)
+	return time.Since(start), err
+}
+
+func TestCancelWhileQueued(t *testing.T) {
+	pool := newTestPool(t, cancelConfig(false))
+
+	busy := make(chan error, 1)
+	go func() {
+		_, err := pool.HandleRequest(&Request{Query: "sleep=300"}, nil)
+		busy <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	elapsed, err := cancelAfter(pool, &Request{}, 50*time.Millisecond)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if elapsed > 200*time.Millisecond {
+		t.Errorf("cancelled request kept waiting for %s", elapsed)
+	}
+	if n := pool.waiting.Load(); n != 0 {
+		t.Errorf("cancelled request still counted as waiting (%d)", n)
+	}
+	if err := <-busy; err != nil {
+		t.Errorf("busy request failed: %v", err)
+	}
+}
+
+func TestCancelInFlight(t *testing.T) {
+	pool := newTestPool(t, cancelConfig(true))
+	pid := pool.Status().Workers[0].PID
+
+	// The worker stops early and is reused
+	elapsed, err := cancelAfter(pool, &Request{Query: "sleep=2000&cancellable=1"}, 50*time.Millisecond)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if elapsed > time.Second {
+		t.Errorf("cancelled request took %s", elapsed)
+	}
+	if _, err := pool.HandleRequest(&Request{}, nil); err != nil {
+		t.Fatalf("request after cancellation failed: %v", err)
+	}
+	if got := pool.Status().Workers[0].PID; got != pid {
+		t.Errorf("worker that acknowledged the cancellation was replaced (pid %d -> %d)", pid, got)
+	}
+
+	// A response that crosses the cancel frame leaves it to be skipped
+	if _, err := cancelAfter(pool, &Request{Query: "sleep=50"}, 10*time.Millisecond); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	resp, err := pool.HandleRequest(&Request{}, nil)
+	if err != nil || string(resp.Body) != "ok" {
+		t.Errorf("worker out of sync after a late cancellation: %v %v", resp, err)
+	}
+	if got := pool.Status().Workers[0].PID; got != pid {
+		t.Errorf("worker that finished within the grace was replaced (pid %d -> %d)", pid, got)
+	}
+}
+
+func TestCancelWithoutCapability(t *testing.T) {
+	pool := newTestPool(t, cancelConfig(false))
+	pid := pool.Status().Workers[0].PID
+
+	// The worker cannot be told to stop, so it finishes the request
+	elapsed, err := cancelAfter(pool, &Request{Query: "sleep=100"}, 50*time.Millisecond)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if elapsed < 80*time.Millisecond {
+		t.Errorf("cancelled request returned after %s, before the worker finished", elapsed)
+	}
+	resp, err := pool.HandleRequest(&Request{}, nil)
+	if err != nil || string(resp.Body) != "ok" {
+		t.Errorf("worker out of sync after a cancellation: %v %v", resp, err)
+	}
+	if got := pool.Status().Workers[0].PID; got != pid {
+		t.Errorf("worker without the cancel capability was replaced (pid %d -> %d)", pid, got)
+	}
+
+	// A streamed response is drained rather than left mid-stream
+	if _, err := cancelAfter(pool, &Request{Query: "sleep=100&chunks=3"}, 50*time.Millisecond); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	resp, err = pool.HandleRequest(&Request{}, nil)
+	if err != nil || string(resp.Body) != "ok" {
+		t.Errorf("worker out of sync after a cancelled stream: %v %v", resp, err)
+	}
+	if got := pool.Status().Workers[0].PID; got != pid {
+		t.Errorf("worker whose stream was drained was replaced (pid %d -> %d)", pid, got)
+	}
+
+	// One still busy after the grace is replaced
+	elapsed, err = cancelAfter(pool, &Request{Query: "sleep=2000"}, 50*time.Millisecond)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if elapsed > time.Second {
+		t.Errorf("cancelled request took %s, expected the worker to be killed after the grace", elapsed)
+	}
+	waitRecovered(t, pool)
+	if got := pool.Status().Workers[0].PID; got == pid {
+		t.Error("worker still busy after the grace was not replaced")
+	}
+}
+
+func TestCancelGraceKillsWorker(t *testing.T) {
+	pool := newTestPool(t, cancelConfig(true))
+	pid := pool.Status().Workers[0].PID
+
+	// The worker ignores the cancellation and is replaced after the grace
+	elapsed, err := cancelAfter(pool, &Request{Query: "sleep=2000"}, 50*time.Millisecond)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if elapsed > time.Second {
+		t.Errorf("cancelled request took %s, expected the worker to be killed after the grace", elapsed)
+	}
+
+	waitRecovered(t, pool)
+	if got := pool.Status().Workers[0].PID; got == pid {
+		t.Error("worker that ignored the cancellation was not replaced")
+	}
+}
+
+// waitRecovered waits until the pool serves requests again after a worker
+// was killed
+func waitRecovered(t *testing.T, pool *Pool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := pool.HandleRequest(&Request{}, nil)
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pool did not recover: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
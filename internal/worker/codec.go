@@ -0,0 +1,125 @@
+package worker
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Supported worker protocols (the "protocol" key in tusk.json)
+const (
+	ProtocolNDJSON  = "ndjson"
+	ProtocolMsgpack = "msgpack"
+)
+
+// maxFrameSize bounds a single length-prefixed frame so a corrupt length
+// cannot make the engine allocate unbounded memory
+const maxFrameSize = 256 << 20
+
+// Codec reads and writes protocol messages on a worker's stdio pipes
+type Codec interface {
+	Encode(v interface{}) error
+	Decode(v interface{}) error
+}
+
+// NewCodec returns the codec for the given protocol name
+func NewCodec(protocol string, r io.Reader, w io.Writer) (Codec, error) {
+	if err := checkProtocol(protocol); err != nil {
+		return nil, err
+	}
+	if protocol == ProtocolMsgpack {
+		return newMsgpackCodec(r, w), nil
+	}
+	return &ndjsonCodec{
+		enc: json.NewEncoder(w),
+		dec: json.NewDecoder(r),
+	}, nil
+}
+
+// checkProtocol rejects protocol names the engine does not speak
+func checkProtocol(protocol string) error {
+	switch protocol {
+	case "", ProtocolNDJSON, ProtocolMsgpack:
+		return nil
+	}
+	return fmt.Errorf("unknown worker protocol %q (expected %q or %q)", protocol, ProtocolNDJSON, ProtocolMsgpack)
+}
+
+// ndjsonCodec speaks newline delimited JSON, one message per line
+type ndjsonCodec struct {
+	enc *json.Encoder
+	dec *json.Decoder
+}
+
+func (c *ndjsonCodec) Encode(v interface{}) error {
+	return c.enc.Encode(v)
+}
+
+func (c *ndjsonCodec) Decode(v interface{}) error {
+	return c.dec.Decode(v)
+}
+
+// msgpackCodec frames each MessagePack message with a 4-byte big-endian
+// length prefix. Strings are passed through as raw bytes, so binary bodies
+// survive the round trip untouched.
+type msgpackCodec struct {
+	r   *bufio.Reader
+	w   io.Writer
+	buf bytes.Buffer
+	enc *msgpack.Encoder
+}
+
+func newMsgpackCodec(r io.Reader, w io.Writer) *msgpackCodec {
+	c := &msgpackCodec{
+		r: bufio.NewReader(r),
+		w: w,
+	}
+	c.enc = msgpack.NewEncoder(&c.buf)
+	c.enc.SetCustomStructTag("json")
+	c.enc.UseCompactInts(true)
+	return c
+}
+
+func (c *msgpackCodec) Encode(v interface{}) error {
+	// Reserve room for the length prefix and encode the payload after it
+	c.buf.Reset()
+	c.buf.Write([]byte{0, 0, 0, 0})
+	if err := c.enc.Encode(v); err != nil {
+		return err
+	}
+
+	frame := c.buf.Bytes()
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)-4))
+	_, err := c.w.Write(frame)
+	return err
+}
+
+func (c *msgpackCodec) Decode(v interface{}) error {
+	var prefix [4]byte
+	if _, err := io.ReadFull(c.r, prefix[:]); err != nil {
+		return err
+	}
+
+	size := binary.BigEndian.Uint32(prefix[:])
+	if size > maxFrameSize {
+		return fmt.Errorf("msgpack frame of %d bytes exceeds limit of %d", size, maxFrameSize)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	dec := msgpack.NewDecoder(bytes.NewReader(payload))
+	dec.SetCustomStructTag("json")
+	dec.UseLooseInterfaceDecoding(true)
+	return dec.Decode(v)
+}
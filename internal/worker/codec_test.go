@@ -0,0 +1,72 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	binaryBody := string([]byte{0x00, 0xff, 0xfe, 0x80, 'x'})
+
+	for _, protocol := range []string{ProtocolNDJSON, ProtocolMsgpack} {
+		t.Run(protocol, func(t *testing.T) {
+			var wire bytes.Buffer
+			codec, err := NewCodec(protocol, &wire, &wire)
+			if err != nil {
+				t.Fatalf("NewCodec failed: %v", err)
+			}
+
+			msg := map[string]interface{}{
+				"status":  201,
+				"headers": map[string][]string{"X-Multi": {"a", "b"}},
+				"body":    binaryBody,
+			}
+			if err := codec.Encode(msg); err != nil {
+				t.Fatalf("Encode failed: %v", err)
+			}
+
+			var got map[string]interface{}
+			if err := codec.Decode(&got); err != nil {
+				t.Fatalf("Decode failed: %v", err)
+			}
+
+			// JSON yields float64, MessagePack an integer type
+			if fmt.Sprint(got["status"]) != "201" {
+				t.Errorf("Unexpected status %v", got["status"])
+			}
+
+			headers, ok := got["headers"].(map[string]interface{})
+			if !ok {
+				t.Fatalf("Invalid headers format: %T", got["headers"])
+			}
+			if multi, ok := headers["X-Multi"].([]interface{}); !ok || len(multi) != 2 {
+				t.Errorf("X-Multi header lost values: %v", headers["X-Multi"])
+			}
+
+			// Only the binary protocol is expected to keep raw bytes intact
+			if protocol == ProtocolMsgpack && got["body"] != binaryBody {
+				t.Errorf("Body mangled: %q", got["body"])
+			}
+		})
+	}
+}
+
+func TestMsgpackFrameLimit(t *testing.T) {
+	var wire bytes.Buffer
+	binary.Write(&wire, binary.BigEndian, uint32(maxFrameSize+1))
+
+	codec, _ := NewCodec(ProtocolMsgpack, &wire, &wire)
+	var got map[string]interface{}
+	if err := codec.Decode(&got); err == nil || !strings.Contains(err.Error(), "exceeds limit") {
+		t.Errorf("Expected frame limit error, got %v", err)
+	}
+}
+
+func TestUnknownProtocol(t *testing.T) {
+	if _, err := NewCodec("xml", nil, nil); err == nil {
+		t.Error("Expected error for unknown protocol")
+	}
+}
@@ -0,0 +1,44 @@
+package worker
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	workerRequestsDesc = prometheus.NewDesc(
+		"tusk_worker_requests",
+		"Requests served by each worker process since it was spawned.",
+		[]string{"worker"}, nil)
+
+	workerRSSDesc = prometheus.NewDesc(
+		"tusk_worker_rss_bytes",
+		"Resident set size of each worker process.",
+		[]string{"worker"}, nil)
+)
+
+// poolCollector reports per-worker stats, read from the live workers on
+// every scrape so respawned and retired workers never leave stale series
+type poolCollector struct {
+	pool *Pool
+}
+
+func (c poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- workerRequestsDesc
+	ch <- workerRSSDesc
+}
+
+func (c poolCollector) Collect(ch chan<- prometheus.Metric) {
+	c.pool.mu.Lock()
+	workers := append([]*Process(nil), c.pool.workers...)
+	c.pool.mu.Unlock()
+
+	for _, w := range workers {
+		id := strconv.Itoa(w.ID)
+		ch <- prometheus.MustNewConstMetric(workerRequestsDesc, prometheus.GaugeValue, float64(w.requests.Load()), id)
+		if rss, err := processRSS(w.cmd.Process.Pid); err == nil {
+			ch <- prometheus.MustNewConstMetric(workerRSSDesc, prometheus.GaugeValue, float64(rss), id)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package worker
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tusk-framework/tusk-engine/internal/metrics"
+)
+
+func TestPoolMetrics(t *testing.T) {
+	pool := newTestPool(t, nil)
+
+	// One busy worker: the second request has to wait for it
+	busy := make(chan struct{})
+	go func() {
+		pool.HandleRequest(&Request{Query: "sleep=300"}, nil)
+		close(busy)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if got := testutil.ToFloat64(metrics.WorkersActive); got != 1 {
+		t.Errorf("workers active = %v, want 1", got)
+	}
+
+	waiting := make(chan struct{})
+	go func() {
+		pool.HandleRequest(&Request{}, nil)
+		close(waiting)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if got := testutil.ToFloat64(metrics.RequestsWaiting); got != 1 {
+		t.Errorf("requests waiting = %v, want 1", got)
+	}
+
+	<-busy
+	<-waiting
+	if got := testutil.ToFloat64(metrics.WorkersActive); got != 0 {
+		t.Errorf("workers active after requests = %v, want 0", got)
+	}
+	if got := testutil.ToFloat64(metrics.RequestsWaiting); got != 0 {
+		t.Errorf("requests waiting after requests = %v, want 0", got)
+	}
+
+	// Per-worker stats come from the live workers
+	collector := poolCollector{pool}
+	want := `
+# HELP tusk_worker_requests Requests served by each worker process since it was spawned.
+# TYPE tusk_worker_requests gauge
+tusk_worker_requests{worker="0"} 2
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(want), "tusk_worker_requests"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExitReason(t *testing.T) {
+	tests := []struct {
+		script string
+		killed bool
+		want   string
+	}{
+		{"exit 0", false, "exit"},
+		{"exit 3", false, "crash"},
+		{"kill -9 $$", false, "signal"},
+		{"kill -9 $$", true, "killed"},
+		{"exit 255", true, "crash"}, // died before the engine killed it
+	}
+
+	for _, tt := range tests {
+		w := &Process{}
+		w.killed.Store(tt.killed)
+		err := exec.Command("sh", "-c", tt.script).Run()
+		if got := exitReason(w, err); got != tt.want {
+			t.Errorf("exitReason(%q, killed=%v) = %q, want %q", tt.script, tt.killed, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// ErrPoolSize is returned by Scale for a size outside 1..max workers
+var ErrPoolSize = errors.New("invalid pool size")
+
+// Worker states reported by Status
+const (
+	StateBooting    = "booting"
+	StateIdle       = "idle"
+	StateBusy       = "busy"
+	StateRetiring   = "retiring"
+	StateRestarting = "restarting"
+)
+
+// WorkerStatus describes a single worker process
+type WorkerStatus struct {
+	ID         int       `json:"id"`
+	PID        int       `json:"pid"`
+	State      string    `json:"state"`
+	Generation uint64    `json:"generation"`
+	StartedAt  time.Time `json:"started_at"`
+	Uptime     float64   `json:"uptime_seconds"`
+	Requests   int64     `json:"requests"`
+	RSS        uint64    `json:"rss_bytes,omitempty"`
+
+	Capabilities []string `json:"capabilities,omitempty"`
+}
+
+// PoolStatus is a snapshot of the pool for the admin API
+type PoolStatus struct {
+	Generation uint64         `json:"generation"`
+	MinWorkers int            `json:"min_workers"`
+	MaxWorkers int            `json:"max_workers"`
+	Workers    []WorkerStatus `json:"workers"`
+	Waiting    int64          `json:"waiting"`
+	CrashLoop  bool           `json:"crash_loop"`
+	LastCrash  *CrashReport   `json:"last_crash,omitempty"`
+}
+
+// Status returns a snapshot of every worker in the pool
+func (p *Pool) Status() PoolStatus {
+	p.mu.Lock()
+	workers := append([]*Process(nil), p.workers...)
+	p.mu.Unlock()
+
+	status := PoolStatus{
+		Generation: p.gen.Load(),
+		MinWorkers: p.minWorkers(),
+		MaxWorkers: p.maxWorkers(),
+		Workers:    make([]WorkerStatus, 0, len(workers)),
+		Waiting:    p.waiting.Load(),
+		CrashLoop:  p.crashLoop() != nil,
+		LastCrash:  p.LastCrash(),
+	}
+	for _, w := range workers {
+		ws := WorkerStatus{
+			ID:         w.ID,
+			PID:        w.cmd.Process.Pid,
+			State:      w.state(),
+			Generation: w.gen,
+			StartedAt:  w.CreatedAt,
+			Uptime:     time.Since(w.CreatedAt).Seconds(),
+			Requests:   w.requests.Load(),
+		}
+		if ws.State != StateBooting {
+			ws.Capabilities = w.Capabilities
+		}
+		if rss, err := processRSS(ws.PID); err == nil {
+			ws.RSS = rss
+		}
+		status.Workers = append(status.Workers, ws)
+	}
+	return status
+}
+
+// state reports what the worker is doing right now
+func (w *Process) state() string {
+	select {
+	case <-w.done:
+		return StateRestarting
+	default:
+	}
+	switch {
+	case w.killed.Load():
+		return StateRestarting
+	case w.retired.Load():
+		return StateRetiring
+	case !w.ready.Load():
+		return StateBooting
+	case w.busy.Load():
+		return StateBusy
+	}
+	return StateIdle
+}
+
+// Scale grows or shrinks the pool to n workers, between 1 and the pool's
+// maximum size. Only idle workers are stopped when shrinking, so the pool
+// may stay larger until busy ones are free. Dynamic pools keep scaling on
+// their own afterwards. It returns the resulting number of workers.
+func (p *Pool) Scale(n int) (int, error) {
+	if n < 1 || n > p.maxWorkers() {
+		return 0, fmt.Errorf("%w: worker count must be between 1 and %d", ErrPoolSize, p.maxWorkers())
+	}
+
+	p.mu.Lock()
+	for len(p.workers) < n {
+		id := p.nextID
+		p.nextID++
+		if err := p.spawnWorker(id); err != nil {
+			size := len(p.workers)
+			p.mu.Unlock()
+			return size, err
+		}
+	}
+	p.mu.Unlock()
+
+	for p.size() > n {
+		w := p.takeIdle()
+		if w == nil {
+			break // the rest are busy
+		}
+		p.mu.Lock()
+		w.retired.Store(true)
+		p.removeWorker(w)
+		p.mu.Unlock()
+		go w.stop()
+	}
+
+	size := p.size()
+	slog.Info("Pool scaled", "requested", n, "workers", size)
+	return size, nil
+}
+
+// size returns the number of workers in the pool
+func (p *Pool) size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.workers)
+}
+
+// takeIdle takes an idle worker out of the queue without blocking
+func (p *Pool) takeIdle() *Process {
+	select {
+	case w := <-p.workerQueue:
+		return w
+	default:
+		return nil
+	}
+}
+
+// KillWorker kills the worker with the given ID; it is respawned like a
+// crashed one. A request it was serving fails.
+func (p *Pool) KillWorker(id int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, w := range p.workers {
+		if w.ID == id {
+			slog.Warn("Killing worker on request", "worker", id, "pid", w.cmd.Process.Pid)
+			w.kill()
+			return nil
+		}
+	}
+	return fmt.Errorf("no worker with id %d", id)
+}
@@ -0,0 +1,54 @@
+package worker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tusk-framework/tusk-engine/internal/config"
+)
+
+func TestScaleAndStatus(t *testing.T) {
+	pool := newTestPool(t, func(cfg *config.Config) {
+		cfg.MinWorkers = 1
+		cfg.MaxWorkers = 3
+	})
+
+	if _, err := pool.Scale(4); !errors.Is(err, ErrPoolSize) {
+		t.Errorf("Scale(4): expected ErrPoolSize, got %v", err)
+	}
+
+	size, err := pool.Scale(3)
+	if err != nil || size != 3 {
+		t.Fatalf("Scale(3) = %d, %v", size, err)
+	}
+	st := pool.Status()
+	if len(st.Workers) != 3 || st.MaxWorkers != 3 {
+		t.Fatalf("unexpected status after scaling up: %+v", st)
+	}
+
+	// A busy worker survives scaling down
+	done := make(chan struct{})
+	go func() {
+		pool.HandleRequest(&Request{Query: "sleep=300"}, nil)
+		close(done)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	size, err = pool.Scale(1)
+	if err != nil || size != 1 {
+		t.Fatalf("Scale(1) = %d, %v", size, err)
+	}
+	st = pool.Status()
+	if len(st.Workers) != 1 || st.Workers[0].State != StateBusy {
+		t.Errorf("expected the busy worker to remain, got %+v", st.Workers)
+	}
+	<-done
+
+	if _, err := pool.HandleRequest(&Request{}, nil); err != nil {
+		t.Errorf("request after scaling down failed: %v", err)
+	}
+	if st := pool.Status(); st.Workers[0].State != StateIdle || st.Workers[0].Requests != 2 {
+		t.Errorf("unexpected worker status: %+v", st.Workers[0])
+	}
+}
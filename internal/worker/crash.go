@@ -0,0 +1,216 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/tusk-framework/tusk-engine/internal/metrics"
+)
+
+// ErrCrashLoop is matched by the error returned while workers keep crashing
+var ErrCrashLoop = errors.New("workers are crash looping")
+
+// CrashReport describes an unexpected worker exit or failed respawn
+type CrashReport struct {
+	Worker int       `json:"worker"`
+	PID    int       `json:"pid,omitempty"`
+	Reason string    `json:"reason"`
+	Error  string    `json:"error"`
+	Time   time.Time `json:"time"`
+	Stderr []string  `json:"stderr,omitempty"`
+}
+
+// CrashLoopError is returned instead of dispatching a request while the
+// pool is crash looping
+type CrashLoopError struct {
+	Crashes int
+	Window  time.Duration
+	Last    CrashReport
+}
+
+func (e *CrashLoopError) Error() string {
+	return fmt.Sprintf("%v: %d crashes in %s, last: worker %d %s (%s)",
+		ErrCrashLoop, e.Crashes, e.Window, e.Last.Worker, e.Last.Reason, e.Last.Error)
+}
+
+func (e *CrashLoopError) Is(target error) bool {
+	return target == ErrCrashLoop
+}
+
+// restartBackoffMax caps the delay between restarts of a worker
+func (p *Pool) restartBackoffMax() time.Duration {
+	if p.cfg.RestartBackoffMax <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(p.cfg.RestartBackoffMax)
+}
+
+// restartDelay returns the backoff before restart attempt n (0-based): it
+// doubles with each attempt and is jittered down by up to half, so workers
+// that crashed together do not restart in lockstep
+func (p *Pool) restartDelay(n int) time.Duration {
+	base := time.Duration(p.cfg.RestartBackoff)
+	if base <= 0 {
+		base = time.Second
+	}
+	limit := p.restartBackoffMax()
+
+	d := limit
+	if n < 30 && base<<n < limit {
+		d = base << n
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// respawn replaces a dead worker, retrying failed spawns with growing
+// delays until one succeeds or the pool stops. With backoff set, as for
+// crashes, the first attempt is delayed too; workers the engine killed on
+// purpose are replaced right away. The old worker keeps its place in the
+// pool until then so its slot is never lost.
+func (p *Pool) respawn(old *Process, backoff bool) {
+	for {
+		attempt := 0
+		if backoff {
+			p.mu.Lock()
+			attempt = p.failures[old.ID]
+			p.failures[old.ID] = attempt + 1
+			p.mu.Unlock()
+
+			select {
+			case <-time.After(p.restartDelay(attempt)):
+			case <-p.ctx.Done():
+				return
+			}
+		}
+
+		// A worker that died while idle still sits in the queue and would
+		// take the slot its replacement needs
+		p.dropQueued(old)
+
+		p.mu.Lock()
+		if p.ctx.Err() != nil {
+			p.mu.Unlock()
+			return
+		}
+		err := p.spawnWorker(old.ID)
+		if err == nil {
+			p.removeWorker(old)
+		}
+		p.mu.Unlock()
+
+		if err == nil {
+			metrics.WorkerRestarts.Inc()
+			return
+		}
+
+		metrics.WorkerSpawnFailures.Inc()
+		slog.Error("Failed to respawn worker, retrying", "worker", old.ID, "attempt", attempt+1, "err", err)
+		p.recordCrash(CrashReport{
+			Worker: old.ID,
+			Reason: "spawn",
+			Error:  err.Error(),
+			Time:   time.Now(),
+		})
+		backoff = true
+	}
+}
+
+// recordCrash feeds the crash loop detector
+func (p *Pool) recordCrash(report CrashReport) {
+	p.crashMu.Lock()
+	p.lastCrash = &report
+	p.crashes = append(p.crashes, report.Time)
+	p.crashMu.Unlock()
+
+	if loop := p.crashLoop(); loop != nil && loop.Crashes == p.cfg.CrashLoopThreshold {
+		slog.Error("Workers are crash looping, rejecting requests",
+			"crashes", loop.Crashes, "window", loop.Window,
+			"worker", report.Worker, "reason", report.Reason, "err", report.Error,
+			"stderr", report.Stderr)
+	}
+}
+
+// crashLoop returns an error while crash_loop_threshold crashes happened
+// within crash_loop_window, or nil if the pool is healthy
+func (p *Pool) crashLoop() *CrashLoopError {
+	threshold := p.cfg.CrashLoopThreshold
+	window := time.Duration(p.cfg.CrashLoopWindow)
+	if threshold <= 0 || window <= 0 {
+		return nil
+	}
+
+	p.crashMu.Lock()
+	defer p.crashMu.Unlock()
+
+	cutoff := time.Now().Add(-window)
+	i := 0
+	for i < len(p.crashes) && p.crashes[i].Before(cutoff) {
+		i++
+	}
+	p.crashes = p.crashes[i:]
+
+	if len(p.crashes) < threshold {
+		metrics.CrashLoop.Set(0)
+		p.signalLoop(false)
+		return nil
+	}
+	metrics.CrashLoop.Set(1)
+	p.signalLoop(true)
+	return &CrashLoopError{Crashes: len(p.crashes), Window: window, Last: *p.lastCrash}
+}
+
+// crashSignal returns a channel that is closed once the pool is crash
+// looping, so requests waiting for a worker fail instead of waiting for one
+// that never boots
+func (p *Pool) crashSignal() <-chan struct{} {
+	p.crashMu.Lock()
+	defer p.crashMu.Unlock()
+	if p.looping == nil {
+		p.looping = make(chan struct{})
+	}
+	return p.looping
+}
+
+// signalLoop closes or rearms the crash signal; p.crashMu must be held
+func (p *Pool) signalLoop(looping bool) {
+	if p.looping == nil {
+		p.looping = make(chan struct{})
+	}
+	select {
+	case <-p.looping:
+		if !looping {
+			p.looping = make(chan struct{})
+		}
+	default:
+		if looping {
+			close(p.looping)
+		}
+	}
+}
+
+// resetCrashes forgets past crashes, e.g. after a reload deployed new code
+func (p *Pool) resetCrashes() {
+	p.crashMu.Lock()
+	p.crashes = nil
+	p.signalLoop(false)
+	p.crashMu.Unlock()
+
+	p.mu.Lock()
+	clear(p.failures)
+	p.mu.Unlock()
+	metrics.CrashLoop.Set(0)
+}
+
+// LastCrash returns the most recent crash, or nil if no worker crashed
+func (p *Pool) LastCrash() *CrashReport {
+	p.crashMu.Lock()
+	defer p.crashMu.Unlock()
+	if p.lastCrash == nil {
+		return nil
+	}
+	report := *p.lastCrash
+	return &report
+}
@@ -0,0 +1,182 @@
+package worker
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tusk-framework/tusk-engine/internal/config"
+	"github.com/tusk-framework/tusk-engine/internal/metrics"
+)
+
+func TestRestartDelay(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.RestartBackoff = config.Duration(100 * time.Millisecond)
+	cfg.RestartBackoffMax = config.Duration(time.Second)
+	p := &Pool{cfg: cfg}
+
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{1, 200 * time.Millisecond},
+		{3, 800 * time.Millisecond},
+		{4, time.Second},
+		{100, time.Second},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			d := p.restartDelay(tt.attempt)
+			if d < tt.max/2 || d > tt.max {
+				t.Errorf("restartDelay(%d) = %s, want between %s and %s", tt.attempt, d, tt.max/2, tt.max)
+			}
+		}
+	}
+}
+
+// crashConfig restarts workers quickly and detects a crash loop after
+// threshold crashes. The handshake is off so Start does not fail on workers
+// that crash while booting.
+func crashConfig(root string, threshold int) func(*config.Config) {
+	return func(cfg *config.Config) {
+		cfg.ProjectRoot = root
+		cfg.WorkerHandshake = false
+		cfg.RestartBackoff = config.Duration(20 * time.Millisecond)
+		cfg.RestartBackoffMax = config.Duration(50 * time.Millisecond)
+		cfg.CrashLoopThreshold = threshold
+		cfg.CrashLoopWindow = config.Duration(10 * time.Second)
+	}
+}
+
+func TestCrashLoop(t *testing.T) {
+	t.Setenv("TUSK_TEST_BOOT", "crash")
+	pool := newTestPool(t, crashConfig("./", 3))
+
+	// Requests fail fast once the loop is detected instead of hanging
+	deadline := time.Now().Add(5 * time.Second)
+	var err error
+	for {
+		_, err = pool.HandleRequest(&Request{}, nil)
+		if errors.Is(err, ErrCrashLoop) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("crash loop not detected, last error: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	var loop *CrashLoopError
+	if !errors.As(err, &loop) || loop.Crashes < 3 {
+		t.Fatalf("expected a CrashLoopError with at least 3 crashes, got %v", err)
+	}
+	if !strings.Contains(strings.Join(loop.Last.Stderr, "\n"), "PHP Parse error") {
+		t.Errorf("last crash does not include the worker's stderr: %+v", loop.Last)
+	}
+	if pool.Ready() {
+		t.Error("crash looping pool reports ready")
+	}
+	if !pool.Status().CrashLoop {
+		t.Error("status does not report the crash loop")
+	}
+
+	// Deploying fixed code and reloading recovers right away
+	os.Setenv("TUSK_TEST_BOOT", "")
+	pool.Reload()
+	deadline = time.Now().Add(5 * time.Second)
+	for {
+		_, err = pool.HandleRequest(&Request{}, nil)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pool did not recover after reload: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestRespawnRetriesSpawnFailures(t *testing.T) {
+	root := t.TempDir()
+	script, err := os.ReadFile("test_worker.php")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(root, "test_worker.php")
+	if err := os.WriteFile(path, script, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pool := newTestPool(t, crashConfig(root, 0))
+
+	// The replacement cannot be spawned while the script is missing
+	failures := testutil.ToFloat64(metrics.WorkerSpawnFailures)
+	os.Remove(path)
+	if err := pool.KillWorker(0); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(metrics.WorkerSpawnFailures)-failures < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("respawn was not retried")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// The slot is kept and filled once spawning works again
+	if err := os.WriteFile(path, script, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	deadline = time.Now().Add(5 * time.Second)
+	for {
+		st := pool.Status()
+		if len(st.Workers) == 1 && st.Workers[0].State == StateIdle {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("worker slot was not refilled: %+v", st.Workers)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if _, err := pool.HandleRequest(&Request{}, nil); err != nil {
+		t.Errorf("request after respawn failed: %v", err)
+	}
+}
+
+func TestTimeoutsAreNotCrashes(t *testing.T) {
+	pool := newTestPool(t, func(cfg *config.Config) {
+		cfg.RequestTimeout = config.Duration(100 * time.Millisecond)
+		cfg.CrashLoopThreshold = 2
+		cfg.CrashLoopWindow = config.Duration(10 * time.Second)
+	})
+	kills := testutil.ToFloat64(metrics.WorkerExits.WithLabelValues("killed"))
+
+	// Each timeout kills the worker, which is replaced without a backoff
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if _, err := pool.HandleRequest(&Request{Query: "sleep=5000"}, nil); !errors.Is(err, ErrTimeout) {
+			t.Fatalf("request %d: expected ErrTimeout, got %v", i, err)
+		}
+	}
+	if _, err := pool.HandleRequest(&Request{}, nil); err != nil {
+		t.Fatalf("request after timeouts failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("replacing timed out workers took %s, expected no backoff", elapsed)
+	}
+
+	if got := testutil.ToFloat64(metrics.WorkerExits.WithLabelValues("killed")) - kills; got != 4 {
+		t.Errorf("expected 4 killed workers to be counted, got %v", got)
+	}
+	if crash := pool.LastCrash(); crash != nil {
+		t.Errorf("timeouts were recorded as a crash: %+v", crash)
+	}
+	if pool.Status().CrashLoop {
+		t.Error("timeouts tripped the crash loop gate")
+	}
+}
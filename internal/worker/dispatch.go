@@ -0,0 +1,30 @@
+package worker
+
+import (
+	"context"
+	"time"
+)
+
+// Dispatch records how the pool served a request, for access logs and
+// tracing. Attach one with WithDispatch and the pool fills it in.
+type Dispatch struct {
+	RequestID string // set by the caller; tags the worker's log output
+
+	WorkerID  int
+	PID       int
+	QueueWait time.Duration // time spent waiting for a free worker
+	Latency   time.Duration // time until the worker's response arrived
+}
+
+type dispatchKey struct{}
+
+// WithDispatch returns a context whose requests record into d
+func WithDispatch(ctx context.Context, d *Dispatch) context.Context {
+	return context.WithValue(ctx, dispatchKey{}, d)
+}
+
+// DispatchFromContext returns the Dispatch attached to ctx, if any
+func DispatchFromContext(ctx context.Context) *Dispatch {
+	d, _ := ctx.Value(dispatchKey{}).(*Dispatch)
+	return d
+}
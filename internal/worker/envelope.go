@@ -0,0 +1,264 @@
+package worker
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// Request is the envelope sent to a worker for an HTTP request or a
+// WebSocket event. Field names on the wire are documented in
+// protocol.schema.json at the repository root.
+type Request struct {
+	// Type is empty for HTTP requests, or the WebSocket event (ws.open,
+	// ws.message, ws.close)
+	Type            string `json:"type,omitempty"`
+	ProtocolVersion int    `json:"protocol_version"` // set by the pool
+	RequestID       string `json:"request_id,omitempty"`
+
+	Method     string            `json:"method,omitempty"`
+	URI        string            `json:"url,omitempty"` // REQUEST_URI, path and query
+	Path       string            `json:"path,omitempty"`
+	Query      string            `json:"query,omitempty"`
+	Protocol   string            `json:"protocol,omitempty"` // SERVER_PROTOCOL, e.g. "HTTP/2.0"
+	Scheme     string            `json:"scheme,omitempty"`
+	Host       string            `json:"host,omitempty"`
+	ServerPort int               `json:"server_port,omitempty"`
+	RemoteAddr string            `json:"remote_addr,omitempty"`
+	RemotePort int               `json:"remote_port,omitempty"`
+	Headers    http.Header       `json:"headers,omitempty"`
+	Cookies    map[string]string `json:"cookies,omitempty"`
+
+	// Body is filled in by the pool from the body passed to HandleRequest;
+	// with streaming on, Stream is set and the body follows as chunk frames
+	Body   string `json:"body,omitempty"`
+	Stream bool   `json:"stream,omitempty"`
+
+	// W3C trace context of the engine's span, set by the pool
+	Traceparent string `json:"traceparent,omitempty"`
+	Tracestate  string `json:"tracestate,omitempty"`
+
+	// WebSocket events
+	Connection string `json:"connection,omitempty"`
+	Data       string `json:"data,omitempty"`
+	Binary     bool   `json:"binary,omitempty"`
+	Encoding   string `json:"encoding,omitempty"` // EncodingBase64 for binary Data over ndjson
+	Code       int    `json:"code,omitempty"`
+}
+
+// Response is a worker's answer to a Request
+type Response struct {
+	Status  int
+	Headers http.Header
+	Body    []byte
+
+	// Stream is set instead of Body when the worker streams its response.
+	// It must be closed to hand the worker back to the pool.
+	Stream io.ReadCloser
+
+	// WS lists WebSocket actions requested by the worker
+	WS []WSAction
+}
+
+// WSAction is a WebSocket action requested in a worker's response
+type WSAction struct {
+	Action     string // send, broadcast, join, leave or close
+	Connection string // target connection, the event's one if empty
+	Channel    string
+	Data       []byte
+	Binary     bool
+	Code       int // close code
+}
+
+// ProtocolError reports a worker response that does not follow the
+// protocol, e.g. a status that is not a number
+type ProtocolError struct {
+	Worker int
+	Field  string // offending field, empty if the whole message is wrong
+	Detail string
+}
+
+func (e *ProtocolError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("worker %d sent an invalid response: %s", e.Worker, e.Detail)
+	}
+	return fmt.Sprintf("worker %d sent an invalid response: %s: %s", e.Worker, e.Field, e.Detail)
+}
+
+func invalid(field, format string, args ...interface{}) *ProtocolError {
+	return &ProtocolError{Field: field, Detail: fmt.Sprintf(format, args...)}
+}
+
+// parseResponse validates a decoded response (or the headers frame of a
+// streamed one) and converts it to a Response
+func parseResponse(msg map[string]interface{}) (*Response, error) {
+	if v, ok := msg["protocol_version"]; ok {
+		if n, ok := intValue(v); !ok || n != ProtocolVersion {
+			return nil, invalid("protocol_version", "engine speaks version %d, got %s", ProtocolVersion, describe(v))
+		}
+	}
+	switch msg["type"] {
+	case nil, "", frameHeaders:
+	default:
+		return nil, invalid("type", "unexpected message type %s", describe(msg["type"]))
+	}
+
+	// 1xx statuses are interim responses that net/http cannot send as the
+	// final one, and the engine performs WebSocket upgrades itself
+	resp := &Response{Status: http.StatusOK}
+	if v, ok := msg["status"]; ok && v != nil {
+		status, ok := intValue(v)
+		if !ok || status < 200 || status > 599 {
+			return nil, invalid("status", "expected an integer between 200 and 599, got %s", describe(v))
+		}
+		resp.Status = status
+	}
+
+	headers, err := parseHeaders(msg["headers"])
+	if err != nil {
+		return nil, err
+	}
+	resp.Headers = headers
+
+	switch body := msg["body"].(type) {
+	case nil:
+	case string:
+		resp.Body = []byte(body)
+	case []byte:
+		resp.Body = body
+	default:
+		return nil, invalid("body", "expected a string, got %s", describe(body))
+	}
+
+	actions, err := parseActions(msg["ws"])
+	if err != nil {
+		return nil, err
+	}
+	resp.WS = actions
+	return resp, nil
+}
+
+// parseHeaders accepts an object whose values are strings, numbers or
+// lists of those. PHP encodes an empty array as a list, so an empty list
+// means no headers.
+func parseHeaders(v interface{}) (http.Header, error) {
+	switch headers := v.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		if len(headers) == 0 {
+			return nil, nil
+		}
+	case map[string]interface{}:
+		h := make(http.Header, len(headers))
+		for k, val := range headers {
+			if list, ok := val.([]interface{}); ok {
+				for _, item := range list {
+					s, ok := scalarString(item)
+					if !ok {
+						return nil, invalid("headers."+k, "expected a string, got %s", describe(item))
+					}
+					h[k] = append(h[k], s)
+				}
+				continue
+			}
+			s, ok := scalarString(val)
+			if !ok {
+				return nil, invalid("headers."+k, "expected a string or a list of strings, got %s", describe(val))
+			}
+			h[k] = []string{s}
+		}
+		return h, nil
+	}
+	return nil, invalid("headers", "expected an object, got %s", describe(v))
+}
+
+// parseActions converts the "ws" list of a response
+func parseActions(v interface{}) ([]WSAction, error) {
+	if v == nil {
+		return nil, nil
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, invalid("ws", "expected a list of actions, got %s", describe(v))
+	}
+
+	actions := make([]WSAction, 0, len(list))
+	for i, item := range list {
+		field := "ws." + strconv.Itoa(i)
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, invalid(field, "expected an object, got %s", describe(item))
+		}
+		action, ok := m["action"].(string)
+		if !ok {
+			return nil, invalid(field+".action", "expected a string, got %s", describe(m["action"]))
+		}
+
+		a := WSAction{Action: action}
+		a.Connection, _ = m["connection"].(string)
+		a.Channel, _ = m["channel"].(string)
+		a.Binary, _ = m["binary"].(bool)
+		a.Code, _ = intValue(m["code"])
+		switch data := m["data"].(type) {
+		case nil:
+		case string:
+			a.Data = []byte(data)
+			if m["encoding"] == EncodingBase64 {
+				decoded, err := base64.StdEncoding.DecodeString(data)
+				if err != nil {
+					return nil, invalid(field+".data", "invalid base64: %v", err)
+				}
+				a.Data = decoded
+			}
+		case []byte:
+			a.Data = data
+		default:
+			return nil, invalid(field+".data", "expected a string, got %s", describe(data))
+		}
+		actions = append(actions, a)
+	}
+	return actions, nil
+}
+
+// scalarString formats a header value. PHP code often passes numbers,
+// e.g. a Content-Length computed with strlen.
+func scalarString(v interface{}) (string, bool) {
+	switch s := v.(type) {
+	case string:
+		return s, true
+	case []byte:
+		return string(s), true
+	case float64:
+		return strconv.FormatFloat(s, 'f', -1, 64), true
+	}
+	if n, ok := intValue(v); ok {
+		return strconv.Itoa(n), true
+	}
+	return "", false
+}
+
+// describe names the JSON type of a decoded value, with the value itself
+// for scalars, for diagnostics
+func describe(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return fmt.Sprintf("boolean %v", v)
+	case string:
+		return fmt.Sprintf("string %q", v)
+	case []byte:
+		return fmt.Sprintf("string %q", v)
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	if _, ok := intValue(v); ok {
+		return fmt.Sprintf("number %v", v)
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,146 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tusk-framework/tusk-engine/internal/metrics"
+)
+
+func TestParseResponse(t *testing.T) {
+	tests := []struct {
+		name  string
+		msg   string
+		field string // expected ProtocolError field, "" if valid
+	}{
+		{"minimal", `{}`, ""},
+		{"full", `{"protocol_version":1,"status":201,"headers":{"X-A":"a","X-B":["b","c"],"Content-Length":2},"body":"ok","ws":[{"action":"send","data":"hi"}]}`, ""},
+		{"php empty headers", `{"status":204,"headers":[],"body":""}`, ""},
+		{"streamed", `{"type":"headers","status":200,"headers":{}}`, ""},
+		{"status string", `{"status":"ok"}`, "status"},
+		{"status range", `{"status":42}`, "status"},
+		{"status informational", `{"status":103}`, "status"},
+		{"status switching protocols", `{"status":101}`, "status"},
+		{"version", `{"protocol_version":99}`, "protocol_version"},
+		{"type", `{"type":"pong"}`, "type"},
+		{"headers list", `{"headers":["X-A: a"]}`, "headers"},
+		{"header value", `{"headers":{"X-A":{"b":1}}}`, "headers.X-A"},
+		{"header list value", `{"headers":{"X-A":["a",null]}}`, "headers.X-A"},
+		{"body", `{"body":["a"]}`, "body"},
+		{"ws", `{"ws":{"action":"send"}}`, "ws"},
+		{"ws action", `{"ws":[{"data":"x"}]}`, "ws.0.action"},
+		{"ws base64", `{"ws":[{"action":"send","data":"AP8=","binary":true,"encoding":"base64"}]}`, ""},
+		{"ws base64 data", `{"ws":[{"action":"send","data":"%%","encoding":"base64"}]}`, "ws.0.data"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var msg map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.msg), &msg); err != nil {
+				t.Fatal(err)
+			}
+			resp, err := parseResponse(msg)
+			if tt.field == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var perr *ProtocolError
+			if !errors.As(err, &perr) {
+				t.Fatalf("expected a ProtocolError, got %v (%+v)", err, resp)
+			}
+			if perr.Field != tt.field {
+				t.Errorf("expected field %q, got %q (%v)", tt.field, perr.Field, perr)
+			}
+		})
+	}
+
+	var msg map[string]interface{}
+	json.Unmarshal([]byte(`{"headers":{"X-B":["b","c"],"Content-Length":2},"ws":[{"action":"close","code":4000}]}`), &msg)
+	resp, _ := parseResponse(msg)
+	if resp.Status != 200 {
+		t.Errorf("expected default status 200, got %d", resp.Status)
+	}
+	if got := resp.Headers["Content-Length"]; len(got) != 1 || got[0] != "2" {
+		t.Errorf("numeric header not converted: %v", got)
+	}
+	if got := resp.Headers["X-B"]; len(got) != 2 {
+		t.Errorf("header list lost values: %v", got)
+	}
+	if len(resp.WS) != 1 || resp.WS[0].Code != 4000 {
+		t.Errorf("unexpected ws actions: %+v", resp.WS)
+	}
+}
+
+func TestProtocolErrors(t *testing.T) {
+	pool := newTestPool(t, nil)
+	pid := pool.Status().Workers[0].PID
+
+	tests := []struct {
+		query string
+		field string
+	}{
+		{"invalid=status", "status"},
+		{"invalid=version", "protocol_version"},
+		{"invalid=headers", "headers.X-Nested"},
+	}
+	for _, tt := range tests {
+		before := testutil.ToFloat64(metrics.WorkerProtocolErrors)
+
+		_, err := pool.HandleRequest(&Request{Query: tt.query}, nil)
+		var perr *ProtocolError
+		if !errors.As(err, &perr) {
+			t.Fatalf("%s: expected a ProtocolError, got %v", tt.query, err)
+		}
+		if perr.Field != tt.field || perr.Worker != 0 {
+			t.Errorf("%s: unexpected error %+v", tt.query, perr)
+		}
+		if got := testutil.ToFloat64(metrics.WorkerProtocolErrors) - before; got != 1 {
+			t.Errorf("%s: expected 1 protocol error to be counted, got %v", tt.query, got)
+		}
+	}
+
+	// The worker finished its message, so it stays in service
+	if _, err := pool.HandleRequest(&Request{}, nil); err != nil {
+		t.Fatalf("request after protocol errors failed: %v", err)
+	}
+	if got := pool.Status().Workers[0].PID; got != pid {
+		t.Errorf("worker was replaced after a protocol error (pid %d -> %d)", pid, got)
+	}
+}
+
+// TestSchemaCoversRequest keeps protocol.schema.json in sync with Request
+func TestSchemaCoversRequest(t *testing.T) {
+	data, err := os.ReadFile("../../protocol.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema struct {
+		Defs map[string]struct {
+			Properties map[string]interface{} `json:"properties"`
+		} `json:"$defs"`
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("invalid schema: %v", err)
+	}
+
+	props := schema.Defs["request"].Properties
+	typ := reflect.TypeOf(Request{})
+	for i := 0; i < typ.NumField(); i++ {
+		name := strings.Split(typ.Field(i).Tag.Get("json"), ",")[0]
+		if _, ok := props[name]; !ok {
+			t.Errorf("request field %q is missing from the schema", name)
+		}
+	}
+	for _, def := range []string{"response", "headers_frame", "chunk", "end", "ready", "ping", "pong", "cancel", "cancelled"} {
+		if _, ok := schema.Defs[def]; !ok {
+			t.Errorf("schema does not define %q", def)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package worker
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/tusk-framework/tusk-engine/internal/metrics"
+)
+
+// ProtocolVersion is the version of the engine/worker protocol. Workers
+// report the version they speak in their ready message.
+const ProtocolVersion = 1
+
+// frameReady is sent once by a booted worker when worker_handshake is on
+const frameReady = "ready"
+
+// defaultBootTimeout applies when boot_timeout is not positive
+const defaultBootTimeout = 30 * time.Second
+
+// boot waits for a freshly spawned worker's ready message and then makes
+// it available. A worker that is not ready within boot_timeout is killed;
+// watchWorker then respawns it.
+func (p *Pool) boot(w *Process) error {
+	timeout := time.Duration(p.cfg.BootTimeout)
+	if timeout <= 0 {
+		timeout = defaultBootTimeout
+	}
+	timer := time.AfterFunc(timeout, w.kill)
+
+	var msg map[string]interface{}
+	err := w.Codec.Decode(&msg)
+	// The timer must be disarmed before the worker can be queued; if it
+	// already fired, the kill may land at any moment, so it timed out
+	if !timer.Stop() || w.killed.Load() {
+		err = fmt.Errorf("worker %d not ready within %s", w.ID, timeout)
+	} else if err != nil {
+		err = fmt.Errorf("worker %d exited before it was ready: %w", w.ID, err)
+	} else {
+		err = w.handshake(msg)
+	}
+	if err != nil {
+		metrics.WorkerBootFailures.Inc()
+		w.kill()
+		return err
+	}
+
+	metrics.WorkerBoot.Observe(time.Since(w.CreatedAt).Seconds())
+	slog.Debug("Worker ready", "worker", w.ID, "boot", time.Since(w.CreatedAt), "capabilities", w.Capabilities)
+	w.ready.Store(true)
+	p.workerQueue <- w
+	return nil
+}
+
+// handshake validates a ready message and records the worker's capabilities
+func (w *Process) handshake(msg map[string]interface{}) error {
+	if msg["type"] != frameReady {
+		return fmt.Errorf("worker %d: expected a ready message, got %v", w.ID, msg["type"])
+	}
+	if v, ok := intValue(msg["protocol_version"]); ok && v != ProtocolVersion {
+		return fmt.Errorf("worker %d speaks protocol version %d, engine supports %d", w.ID, v, ProtocolVersion)
+	}
+	if caps, ok := msg["capabilities"].([]interface{}); ok {
+		for _, c := range caps {
+			if s, ok := c.(string); ok {
+				w.Capabilities = append(w.Capabilities, s)
+			}
+		}
+	}
+	return nil
+}
+
+// intValue converts a decoded number to an int. JSON yields float64 while
+// MessagePack yields integer types.
+func intValue(v interface{}) (int, bool) {
+	switch n := v.(type) {
+	case float64:
+		return int(n), true
+	case int64:
+		return int(n), true
+	case uint64:
+		return int(n), true
+	case int:
+		return n, true
+	}
+	return 0, false
+}
@@ -0,0 +1,86 @@
+package worker
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tusk-framework/tusk-engine/internal/config"
+)
+
+// handshakeConfig runs two workers that must boot within 500ms
+func handshakeConfig(protocol string) func(*config.Config) {
+	return func(cfg *config.Config) {
+		cfg.WorkerCount = 2
+		cfg.Protocol = protocol
+		cfg.WorkerHandshake = true
+		cfg.BootTimeout = config.Duration(500 * time.Millisecond)
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	for _, protocol := range []string{ProtocolNDJSON, ProtocolMsgpack} {
+		t.Run(protocol, func(t *testing.T) {
+			if protocol == ProtocolMsgpack {
+				requireMsgpack(t)
+			}
+			t.Setenv("TUSK_TEST_BOOT", "slow")
+			pool := newUnstartedPool(t, handshakeConfig(protocol))
+
+			start := time.Now()
+			started := make(chan error, 1)
+			go func() { started <- pool.Start() }()
+
+			// The pool can be inspected while its workers boot
+			time.Sleep(50 * time.Millisecond)
+			before := time.Now()
+			if st := pool.Status(); len(st.Workers) != 2 {
+				t.Errorf("expected 2 booting workers, got %+v", st.Workers)
+			}
+			if elapsed := time.Since(before); elapsed > 100*time.Millisecond {
+				t.Errorf("Status blocked for %s while the workers booted", elapsed)
+			}
+
+			if err := <-started; err != nil {
+				t.Fatalf("Failed to start pool: %v", err)
+			}
+			if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+				t.Errorf("Start returned after %s, before the workers were ready", elapsed)
+			}
+
+			// No warm-up sleep needed
+			if _, err := pool.HandleRequest(&Request{}, nil); err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			for _, w := range pool.Status().Workers {
+				if strings.Join(w.Capabilities, ",") != "streaming,ws,cancel" {
+					t.Errorf("worker %d: unexpected capabilities %v", w.ID, w.Capabilities)
+				}
+			}
+		})
+	}
+}
+
+func TestHandshakeFailures(t *testing.T) {
+	tests := []struct {
+		boot string
+		want string
+	}{
+		{"never", "not ready within"},
+		{"future", "protocol version 99"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.boot, func(t *testing.T) {
+			t.Setenv("TUSK_TEST_BOOT", tt.boot)
+			pool := newUnstartedPool(t, handshakeConfig(ProtocolNDJSON))
+			err := pool.Start()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected a boot error containing %q, got %v", tt.want, err)
+			}
+			if pool.Ready() {
+				t.Error("pool without ready workers reports ready")
+			}
+		})
+	}
+}
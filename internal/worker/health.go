@@ -0,0 +1,130 @@
+package worker
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/tusk-framework/tusk-engine/internal/metrics"
+)
+
+// defaultHealthCheckTimeout applies when health_check_timeout is not
+// positive, so a hung worker cannot block the health checker
+const defaultHealthCheckTimeout = 5 * time.Second
+
+// HealthyWorkers returns the number of live workers able to take requests
+func (p *Pool) HealthyWorkers() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := 0
+	for _, w := range p.workers {
+		switch w.state() {
+		case StateIdle, StateBusy:
+			n++
+		}
+	}
+	return n
+}
+
+// Ready reports whether the pool is running, not crash looping and has at
+// least ready_min_workers healthy workers
+func (p *Pool) Ready() bool {
+	select {
+	case <-p.ctx.Done():
+		return false
+	default:
+	}
+	if p.crashLoop() != nil {
+		return false
+	}
+	return p.HealthyWorkers() >= p.readyMinWorkers()
+}
+
+// readyMinWorkers caps ready_min_workers at the pool's minimum size so a
+// pool that scaled down stays ready
+func (p *Pool) readyMinWorkers() int {
+	n := p.cfg.ReadyMinWorkers
+	if n < 1 {
+		n = 1
+	}
+	if n > p.minWorkers() {
+		n = p.minWorkers()
+	}
+	return n
+}
+
+// healthMonitor pings idle workers every health_check_interval
+func (p *Pool) healthMonitor() {
+	interval := time.Duration(p.cfg.HealthCheckInterval)
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.checkIdle()
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+// checkIdle takes each idle worker out of the queue in turn and pings it.
+// Workers that do not answer in time are killed and respawned.
+func (p *Pool) checkIdle() {
+	for i := len(p.workerQueue); i > 0; i-- {
+		w := p.takeIdle()
+		if w == nil {
+			return
+		}
+		if !p.current(w) {
+			continue
+		}
+
+		if err := p.ping(w); err != nil {
+			metrics.WorkerHealthFailures.Inc()
+			slog.Warn("Worker failed health check, replacing", "worker", w.ID, "err", err)
+			w.kill()
+			continue
+		}
+		p.workerQueue <- w
+	}
+}
+
+// ping sends a ping frame and waits for the pong, killing the worker once
+// health_check_timeout expires
+func (p *Pool) ping(w *Process) error {
+	timeout := time.Duration(p.cfg.HealthCheckTimeout)
+	if timeout <= 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+	timer := time.AfterFunc(timeout, w.kill)
+	defer timer.Stop()
+
+	if err := w.Codec.Encode(map[string]interface{}{"type": framePing}); err != nil {
+		return err
+	}
+	var resp map[string]interface{}
+	err := w.Codec.Decode(&resp)
+	// A timer that already fired may still kill the worker after it is
+	// queued again, so treat a late pong as no answer
+	if !timer.Stop() {
+		w.kill()
+		return fmt.Errorf("no answer within %s", timeout)
+	}
+	if err != nil {
+		if w.killed.Load() {
+			return fmt.Errorf("no answer within %s", timeout)
+		}
+		return err
+	}
+	if resp["type"] != framePong {
+		return fmt.Errorf("unexpected health check reply %v", resp["type"])
+	}
+	return nil
+}
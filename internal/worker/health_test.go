@@ -0,0 +1,57 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tusk-framework/tusk-engine/internal/config"
+	"github.com/tusk-framework/tusk-engine/internal/metrics"
+)
+
+func TestHealthCheck(t *testing.T) {
+	pool := newTestPool(t, func(cfg *config.Config) {
+		cfg.HealthCheckInterval = config.Duration(100 * time.Millisecond)
+		cfg.HealthCheckTimeout = config.Duration(200 * time.Millisecond)
+	})
+
+	if !pool.Ready() {
+		t.Fatal("expected a started pool to be ready")
+	}
+	failures := testutil.ToFloat64(metrics.WorkerHealthFailures)
+	pid := pool.Status().Workers[0].PID
+
+	// The worker stops answering pings and must be replaced
+	if _, err := pool.HandleRequest(&Request{Query: "mute=1"}, nil); err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		st := pool.Status()
+		if len(st.Workers) == 1 && st.Workers[0].PID != pid && pool.Ready() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unresponsive worker was not replaced: %+v", st)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if got := testutil.ToFloat64(metrics.WorkerHealthFailures) - failures; got < 1 {
+		t.Errorf("expected a health check failure to be counted, got %v", got)
+	}
+
+	// The replacement answers pings and keeps serving
+	time.Sleep(300 * time.Millisecond)
+	if _, err := pool.HandleRequest(&Request{}, nil); err != nil {
+		t.Errorf("request after replacement failed: %v", err)
+	}
+	if got := pool.Status().Workers[0].PID; got == pid {
+		t.Error("worker was not replaced")
+	}
+
+	pool.Stop()
+	if pool.Ready() {
+		t.Error("expected a stopped pool not to be ready")
+	}
+}
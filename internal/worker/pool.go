@@ -3,21 +3,33 @@ package worker
 import (
 	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/tusk-framework/tusk-engine/internal/config"
 	"github.com/tusk-framework/tusk-engine/internal/metrics"
 	"github.com/tusk-framework/tusk-engine/internal/php"
+	"github.com/tusk-framework/tusk-engine/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// tracerName identifies the pool's spans
+const tracerName = "github.com/tusk-framework/tusk-engine/internal/worker"
+
+// ErrTimeout is returned when a worker does not answer within the request timeout
+var ErrTimeout = errors.New("worker request timed out")
+
 // Process represents a single PHP worker process
 type Process struct {
 	cmd       *exec.Cmd
@@ -25,8 +37,45 @@ type Process struct {
 	CreatedAt time.Time
 	Stdin     io.WriteCloser
 	Stdout    io.ReadCloser
-	Enc       *json.Encoder
-	Dec       *json.Decoder
+	Codec     Codec
+
+	// Capabilities the worker announced in its ready message
+	Capabilities []string
+
+	gen       uint64 // pool generation the worker was spawned in
+	requests  atomic.Int64
+	idleSince atomic.Int64 // unix nanoseconds of the last finished request
+	killed    atomic.Bool
+	busy      atomic.Bool
+	ready     atomic.Bool // set once booted, see worker_handshake
+	booted    chan error  // receives the boot result
+	retired   atomic.Bool
+	requestID atomic.Value  // string ID of the current (or last) request, for stderr logs
+	done      chan struct{} // closed once the process has exited
+
+	stderrTail lineTail      // last stderr lines, for crash reports
+	stderrDone chan struct{} // closed once stderr is fully read
+}
+
+// currentRequest returns the ID of the request the worker is serving. It is
+// kept after the request finishes since stderr is read asynchronously and
+// may lag behind the response.
+func (w *Process) currentRequest() string {
+	id, _ := w.requestID.Load().(string)
+	return id
+}
+
+// lastUsed returns when the worker last finished a request (or was spawned)
+func (w *Process) lastUsed() time.Time {
+	return time.Unix(0, w.idleSince.Load())
+}
+
+// kill terminates the worker process; watchWorker respawns it
+func (w *Process) kill() {
+	w.killed.Store(true)
+	if w.cmd.Process != nil {
+		w.cmd.Process.Kill()
+	}
 }
 
 // Pool manages a set of PHP worker processes
@@ -35,10 +84,24 @@ type Pool struct {
 	phpMgr      *php.Manager
 	workers     []*Process
 	workerQueue chan *Process
+	nextID      int
+	gen         atomic.Uint64 // bumped by Reload
+	exported    bool          // per-worker stats registered with Prometheus
+	failures    map[int]int   // consecutive restarts per worker ID, for backoff
 	mu          sync.Mutex
 	ctx         context.Context
 	cancel      context.CancelFunc
 	wg          sync.WaitGroup
+
+	// Request queue, see acquire
+	handoff         chan *Process // workers passed to waiting priority requests
+	waiting         atomic.Int64  // requests waiting for a worker
+	priorityWaiting atomic.Int32  // priority requests among them
+
+	crashMu   sync.Mutex
+	crashes   []time.Time // recent crashes, for crash loop detection
+	lastCrash *CrashReport
+	looping   chan struct{} // closed while crash looping, see crashSignal
 }
 
 // NewPool creates a new worker pool
@@ -49,30 +112,62 @@ func NewPool(cfg *config.Config) (*Pool, error) {
 		return nil, fmt.Errorf("failed to initialize PHP manager: %w", err)
 	}
 
+	// Fail early on a misspelled protocol rather than on the first spawn
+	if err := checkProtocol(cfg.Protocol); err != nil {
+		return nil, err
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
-	return &Pool{
-		cfg:         cfg,
-		phpMgr:      mgr,
-		workerQueue: make(chan *Process, cfg.WorkerCount),
-		ctx:         ctx,
-		cancel:      cancel,
-	}, nil
+	p := &Pool{
+		cfg:    cfg,
+		phpMgr: mgr,
+		ctx:    ctx,
+		cancel: cancel,
+
+		failures: make(map[int]int),
+	}
+	// Sized for the largest the pool can grow to
+	p.workerQueue = make(chan *Process, p.maxWorkers())
+	p.handoff = make(chan *Process)
+	return p, nil
 }
 
 // Start spawns the configured number of workers
 func (p *Pool) Start() error {
 	p.mu.Lock()
-	defer p.mu.Unlock()
-
-	log.Printf("Starting %d PHP workers...", p.cfg.WorkerCount)
-
-	metrics.WorkersTotal.Set(float64(p.cfg.WorkerCount))
+	count := p.minWorkers()
+	if p.dynamic() {
+		slog.Info("Starting PHP workers", "count", count, "max", p.maxWorkers())
+		go p.monitor()
+	} else {
+		slog.Info("Starting PHP workers", "count", count)
+	}
+	go p.healthMonitor()
 
-	for i := 0; i < p.cfg.WorkerCount; i++ {
+	for i := 0; i < count; i++ {
 		if err := p.spawnWorker(i); err != nil {
+			p.mu.Unlock()
 			return err
 		}
 	}
+	p.nextID = count
+	booting := append([]*Process(nil), p.workers...)
+	p.mu.Unlock()
+
+	// Requests may arrive as soon as Start returns. The lock is released
+	// first so status and health checks answer while the workers boot.
+	for _, w := range booting {
+		if err := <-w.booted; err != nil {
+			return err
+		}
+	}
+
+	// Only one pool's per-worker stats can be exported at a time
+	if err := prometheus.Register(poolCollector{p}); err != nil {
+		slog.Debug("Per-worker metrics not registered", "err", err)
+	} else {
+		p.exported = true
+	}
 	return nil
 }
 
@@ -98,6 +193,11 @@ func (p *Pool) spawnWorker(id int) error {
 	}
 
 	cmd := exec.Command(p.phpMgr.BinaryPath, args...)
+	// Let the worker script pick the matching codec
+	cmd.Env = append(os.Environ(), "TUSK_PROTOCOL="+p.protocol())
+	if p.cfg.WorkerHandshake {
+		cmd.Env = append(cmd.Env, "TUSK_HANDSHAKE=1")
+	}
 
 	// Wire up Pipes
 	stdin, err := cmd.StdinPipe()
@@ -109,10 +209,24 @@ func (p *Pool) spawnWorker(id int) error {
 		return fmt.Errorf("failed to get stdout pipe: %w", err)
 	}
 
-	// stderr can go to main log
-	cmd.Stderr = os.Stderr
+	codec, err := NewCodec(p.cfg.Protocol, stdout, stdin)
+	if err != nil {
+		return err
+	}
+
+	// stderr is parsed and logged per line, see readStderr. Unlike
+	// StderrPipe, Wait does not close this pipe, so the last lines of a
+	// crashing worker (usually the fatal error) are not lost.
+	stderr, stderrW, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create stderr pipe: %w", err)
+	}
+	cmd.Stderr = stderrW
 
-	if err := cmd.Start(); err != nil {
+	err = cmd.Start()
+	stderrW.Close() // The child has its own copy
+	if err != nil {
+		stderr.Close()
 		return fmt.Errorf("failed to start worker %d: %w", id, err)
 	}
 
@@ -122,23 +236,45 @@ func (p *Pool) spawnWorker(id int) error {
 		CreatedAt: time.Now(),
 		Stdin:     stdin,
 		Stdout:    stdout,
-		Enc:       json.NewEncoder(stdin),
-		Dec:       json.NewDecoder(stdout),
+		Codec:     codec,
+		gen:       p.gen.Load(),
+		done:      make(chan struct{}),
+		booted:    make(chan error, 1),
+
+		stderrDone: make(chan struct{}),
 	}
+	worker.idleSince.Store(worker.CreatedAt.UnixNano())
 	p.workers = append(p.workers, worker)
-
-	// Add to available queue
-	p.workerQueue <- worker
+	metrics.WorkersTotal.Set(float64(len(p.workers)))
 
 	// Watch the process in a goroutine
+	go p.readStderr(worker, stderr)
 	go p.watchWorker(worker)
 
+	if !p.cfg.WorkerHandshake {
+		// Add to available queue
+		worker.ready.Store(true)
+		p.workerQueue <- worker
+		worker.booted <- nil
+		return nil
+	}
+
+	// Add to available queue once it reports ready
+	go func() {
+		err := p.boot(worker)
+		if err != nil && p.ctx.Err() == nil {
+			slog.Error("Worker failed to boot", "worker", id, "err", err)
+		}
+		worker.booted <- err
+	}()
+
 	return nil
 }
 
 // watchWorker monitors a worker process and restarts it if it exits
 func (p *Pool) watchWorker(worker *Process) {
 	err := worker.cmd.Wait()
+	close(worker.done)
 
 	// Check if the pool is shutting down
 	select {
@@ -147,72 +283,328 @@ func (p *Pool) watchWorker(worker *Process) {
 	default:
 	}
 
-	log.Printf("Worker %d exited: %v. Restarting...", worker.ID, err)
+	// Recycled workers were already replaced
+	if worker.retired.Load() {
+		return
+	}
+
+	reason := exitReason(worker, err)
+	metrics.WorkerExits.WithLabelValues(reason).Inc()
+
+	// Workers the engine killed on purpose (timeouts, failed health checks,
+	// abandoned streams, admin kills) are not crashes, so they neither
+	// back off nor count towards a crash loop. Failing to boot does.
+	if reason == "killed" && worker.ready.Load() {
+		slog.Info("Worker killed, replacing", "worker", worker.ID)
+		p.respawn(worker, false)
+		return
+	}
 
-	// Simple backoff
-	time.Sleep(1 * time.Second)
+	// Give the reader a moment for the last lines, usually the fatal error
+	select {
+	case <-worker.stderrDone:
+	case <-time.After(time.Second):
+	}
+	p.recordCrash(CrashReport{
+		Worker: worker.ID,
+		PID:    worker.cmd.Process.Pid,
+		Reason: reason,
+		Error:  fmt.Sprint(err),
+		Time:   time.Now(),
+		Stderr: worker.stderrTail.snapshot(),
+	})
+	slog.Warn("Worker exited, restarting", "worker", worker.ID, "reason", reason, "err", err)
 
 	p.mu.Lock()
-	defer p.mu.Unlock()
+	if time.Since(worker.CreatedAt) >= p.restartBackoffMax() {
+		// It ran long enough to not count towards the backoff
+		p.failures[worker.ID] = 0
+	}
+	p.mu.Unlock()
 
-	// Remove old worker from p.workers list
+	p.respawn(worker, true)
+}
+
+// exitReason classifies an unexpected worker exit: "killed" by the engine
+// (timeout, broken stream), "signal" from elsewhere, "crash" for a non-zero
+// exit status and "exit" for a clean exit. A worker the engine gave up on
+// after it had already exited on its own keeps the reason of that exit.
+func exitReason(w *Process, err error) string {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return "exit"
+	}
+	if exitErr.ExitCode() != -1 {
+		return "crash"
+	}
+	if w.killed.Load() {
+		return "killed"
+	}
+	return "signal"
+}
+
+// dropQueued takes a worker out of the idle queue if it is there
+func (p *Pool) dropQueued(worker *Process) {
+	for i := len(p.workerQueue); i > 0; i-- {
+		w := p.takeIdle()
+		if w == nil {
+			return
+		}
+		if w != worker {
+			p.workerQueue <- w
+		}
+	}
+}
+
+// removeWorker drops a worker from p.workers; p.mu must be held
+func (p *Pool) removeWorker(worker *Process) {
 	for i, w := range p.workers {
 		if w == worker {
 			p.workers = append(p.workers[:i], p.workers[i+1:]...)
 			break
 		}
 	}
+	metrics.WorkersTotal.Set(float64(len(p.workers)))
+}
+
+// protocol returns the configured worker protocol name
+func (p *Pool) protocol() string {
+	if p.cfg.Protocol == "" {
+		return ProtocolNDJSON
+	}
+	return p.cfg.Protocol
+}
 
-	p.spawnWorker(worker.ID)
+// HandleRequest dispatches a request to an available worker. When the worker
+// streams its response, resp.Stream must be closed to hand the worker back
+// to the pool.
+func (p *Pool) HandleRequest(req *Request, body io.Reader) (*Response, error) {
+	return p.HandleRequestContext(context.Background(), req, body)
 }
 
-// HandleRequest dispatches a request to an available worker
-func (p *Pool) HandleRequest(req map[string]interface{}, body io.Reader) (map[string]interface{}, error) {
-	// 1. Prepare body (if exists)
-	if body != nil {
+// HandleRequestContext is HandleRequest with a context, which may carry a
+// Dispatch to record which worker served the request. Cancelling ctx stops
+// waiting for a worker, or asks the worker to stop (see cancel_grace).
+func (p *Pool) HandleRequestContext(ctx context.Context, req *Request, body io.Reader) (resp *Response, err error) {
+	// Fail fast rather than queueing for workers that keep crashing
+	if loop := p.crashLoop(); loop != nil {
+		return nil, loop
+	}
+
+	// 1. Prepare body (if exists). In streaming mode it is sent as chunk
+	// frames after the request instead.
+	req.ProtocolVersion = ProtocolVersion
+	if p.cfg.Streaming {
+		req.Stream = true
+	} else if body != nil {
 		buf := new(bytes.Buffer)
 		if _, err := io.Copy(buf, body); err != nil {
 			return nil, fmt.Errorf("failed to read request body: %w", err)
 		}
-		req["body"] = buf.String()
+		req.Body = buf.String()
 	}
+
+	// Idempotent requests are retried on another worker when theirs dies,
+	// as long as no streamed body has been consumed
+	retries := 0
+	if idempotent(req) {
+		retries = p.cfg.MaxRetries
+	}
+	var guard *readCounter
+	if p.cfg.Streaming && body != nil {
+		guard = &readCounter{r: body}
+		body = guard
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err = p.exchange(ctx, req, body)
+		var broken *brokenError
+		if err == nil || attempt >= retries || ctx.Err() != nil || !errors.As(err, &broken) || (guard != nil && guard.n > 0) {
+			return resp, err
+		}
+		metrics.RequestRetries.Inc()
+		slog.Warn("Worker died, retrying request on another worker", "method", req.Method, "attempt", attempt+1, "err", err)
+	}
+}
+
+// exchange sends a request to an available worker and reads its response
+func (p *Pool) exchange(ctx context.Context, req *Request, body io.Reader) (resp *Response, err error) {
 	// Pick an available worker from the queue (blocks if all busy)
-	var w *Process
-	select {
-	case w = <-p.workerQueue:
-		// Got a worker
-	case <-p.ctx.Done():
-		return nil, fmt.Errorf("pool shutting down")
+	tracer := tracing.Tracer(tracerName)
+	_, queueSpan := tracer.Start(ctx, "worker.queue")
+	queued := time.Now()
+	w, err := p.acquire(ctx)
+	queueSpan.End()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, span := tracer.Start(ctx, "worker.request",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.Int("tusk.worker.id", w.ID),
+			attribute.Int("tusk.worker.pid", w.cmd.Process.Pid),
+		))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+	// Let PHP-side instrumentation continue the trace
+	req.Traceparent, req.Tracestate = tracing.Inject(ctx)
+
+	sent := time.Now()
+	metrics.QueueWait.Observe(sent.Sub(queued).Seconds())
+	dispatch := DispatchFromContext(ctx)
+	if dispatch != nil {
+		dispatch.WorkerID = w.ID
+		dispatch.PID = w.cmd.Process.Pid
+		dispatch.QueueWait = sent.Sub(queued)
+		w.requestID.Store(dispatch.RequestID)
 	}
 
 	p.wg.Add(1)
-	defer p.wg.Done()
+	w.busy.Store(true)
+	metrics.WorkersActive.Inc()
 
-	// Always put the worker back (or handle its death)
+	// Always put the worker back (or handle its death), unless a body
+	// stream has taken ownership of it
+	streaming := false
 	defer func() {
-		// Only put back if the command is still running
-		if w.cmd.ProcessState == nil || !w.cmd.ProcessState.Exited() {
-			p.workerQueue <- w
+		if !streaming {
+			p.finish(w)
 		}
 	}()
 
+	// A hung worker would block Decode forever, so kill it once the
+	// timeout expires; the pipe then breaks and watchWorker respawns it
+	var timedOut atomic.Bool
+	var timer *time.Timer
+	timeout := time.Duration(p.cfg.RequestTimeout)
+	if timeout > 0 {
+		timer = time.AfterFunc(timeout, func() {
+			timedOut.Store(true)
+			w.kill()
+		})
+		defer timer.Stop()
+	}
+
 	// Send
-	if err := w.Enc.Encode(req); err != nil {
-		return nil, fmt.Errorf("worker %d encode error: %w", w.ID, err)
+	if err := w.Codec.Encode(req); err != nil {
+		return nil, p.relayError(w, &timedOut, &brokenError{fmt.Errorf("worker %d encode error: %w", w.ID, err)})
+	}
+	if p.cfg.Streaming {
+		if err := sendBody(w, p.cfg.Protocol, body); err != nil {
+			return nil, p.relayError(w, &timedOut, err)
+		}
 	}
 
-	// Receive
-	var resp map[string]interface{}
-	if err := w.Dec.Decode(&resp); err != nil {
-		return nil, fmt.Errorf("worker %d decode error: %w", w.ID, err)
+	// Receive. From here on a client that goes away is reported to the
+	// worker, which may stop early.
+	cancel := p.watchCancel(ctx, w)
+	var msg map[string]interface{}
+	err = w.Codec.Decode(&msg)
+	// A timeout that fired as the response arrived may still be about to
+	// kill the worker; kill it now so it is not handed out again
+	if timer != nil && !timer.Stop() {
+		timedOut.Store(true)
+		w.kill()
+	}
+	if cancel.release() {
+		// The worker answered and is reused. The rest of a streamed
+		// response is drained first, see bodyStream.Close.
+		if err != nil {
+			w.kill()
+		} else if msg["type"] == frameHeaders {
+			if resp, err := parseResponse(msg); err == nil {
+				streaming = true
+				newBodyStream(p, w, resp).Close()
+			} else {
+				w.kill()
+			}
+		}
+		return nil, cancel.cancelled(ctx)
+	}
+	if err != nil {
+		return nil, p.relayError(w, &timedOut, &brokenError{fmt.Errorf("worker %d decode error: %w", w.ID, err)})
+	}
+
+	resp, err = parseResponse(msg)
+	if err != nil {
+		// Frames may follow anything but a plain response
+		if t := msg["type"]; t != nil && t != "" {
+			w.kill()
+		}
+		metrics.WorkerProtocolErrors.Inc()
+		err.(*ProtocolError).Worker = w.ID
+		return nil, err
+	}
+
+	latency := time.Since(sent)
+	metrics.WorkerExecution.Observe(latency.Seconds())
+	if dispatch != nil {
+		dispatch.Latency = latency
+	}
+
+	if msg["type"] == frameHeaders {
+		resp.Stream = newBodyStream(p, w, resp)
+		streaming = true
 	}
 
 	return resp, nil
 }
 
+// relayError reports a failed exchange, turning errors caused by the
+// timeout killing the worker into ErrTimeout. The worker is left mid
+// message, so it is killed rather than handed to the next request.
+func (p *Pool) relayError(w *Process, timedOut *atomic.Bool, err error) error {
+	w.kill()
+	if timedOut.Load() {
+		metrics.WorkerTimeouts.Inc()
+		return fmt.Errorf("worker %d: %w after %s", w.ID, ErrTimeout, time.Duration(p.cfg.RequestTimeout))
+	}
+	return err
+}
+
+// finish returns a worker to the queue once its request is complete
+func (p *Pool) finish(w *Process) {
+	defer p.release()
+	w.busy.Store(false)
+
+	// Killed workers are respawned by watchWorker instead
+	if w.killed.Load() {
+		return
+	}
+
+	w.requests.Add(1)
+	w.idleSince.Store(time.Now().UnixNano())
+	if reason := p.recycleReason(w); reason != "" {
+		p.retire(w, reason)
+		return
+	}
+
+	// Only put back if the command is still running
+	select {
+	case <-w.done:
+	default:
+		p.workerQueue <- w
+	}
+}
+
+// release marks a request as no longer holding a worker
+func (p *Pool) release() {
+	metrics.WorkersActive.Dec()
+	p.wg.Done()
+}
+
 // Stop terminates all workers
 func (p *Pool) Stop() {
 	p.cancel()
+	if p.exported {
+		prometheus.Unregister(poolCollector{p})
+	}
 
 	// Wait for active requests to finish
 	// We give them a few seconds then kill
@@ -224,9 +616,9 @@ func (p *Pool) Stop() {
 
 	select {
 	case <-done:
-		log.Println("All active requests finished.")
+		slog.Info("All active requests finished")
 	case <-time.After(5 * time.Second):
-		log.Println("Timeout waiting for requests, killing workers...")
+		slog.Warn("Timeout waiting for requests, killing workers")
 	}
 
 	p.mu.Lock()
@@ -1,30 +1,76 @@
 package worker
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
 	"testing"
 	"time"
 
 	"github.com/tusk-framework/tusk-engine/internal/config"
 )
 
-func TestPoolConcurrency(t *testing.T) {
+// requirePHP skips tests that need a real PHP binary to run test_worker.php
+func requirePHP(tb testing.TB) {
+	tb.Helper()
+	if _, err := exec.LookPath("php"); err != nil {
+		tb.Skip("php binary not found in PATH")
+	}
+}
+
+// requireMsgpack skips tests that run test_worker.php over the msgpack
+// protocol, which needs the msgpack PHP extension
+func requireMsgpack(tb testing.TB) {
+	tb.Helper()
+	requirePHP(tb)
+	if err := exec.Command("php", "-r", `exit(extension_loaded("msgpack") ? 0 : 1);`).Run(); err != nil {
+		tb.Skip("msgpack PHP extension not loaded")
+	}
+}
+
+// newTestPool starts a pool of one test_worker.php worker, with the config
+// adjusted by configure if it is not nil. The handshake is on, so the
+// workers are ready once it returns. The pool is stopped when the test ends.
+func newTestPool(tb testing.TB, configure func(*config.Config)) *Pool {
+	tb.Helper()
+	pool := newUnstartedPool(tb, configure)
+	if err := pool.Start(); err != nil {
+		tb.Fatalf("Failed to start pool: %v", err)
+	}
+	return pool
+}
+
+// newUnstartedPool is newTestPool for tests that start the pool themselves
+func newUnstartedPool(tb testing.TB, configure func(*config.Config)) *Pool {
+	tb.Helper()
+	requirePHP(tb)
+
 	cfg := config.DefaultConfig()
-	cfg.WorkerCount = 2
+	cfg.WorkerCount = 1
 	cfg.WorkerCommand = "test_worker.php"
 	cfg.ProjectRoot = "./"
+	cfg.WorkerHandshake = true
+	if configure != nil {
+		configure(cfg)
+	}
 
 	pool, err := NewPool(cfg)
 	if err != nil {
-		t.Fatalf("Failed to create pool: %v", err)
-	}
-
-	if err := pool.Start(); err != nil {
-		t.Fatalf("Failed to start pool: %v", err)
+		tb.Fatalf("Failed to create pool: %v", err)
 	}
-	defer pool.Stop()
+	tb.Cleanup(pool.Stop)
+	return pool
+}
 
-	// Wait for workers to start
-	time.Sleep(100 * time.Millisecond)
+func TestPoolConcurrency(t *testing.T) {
+	pool := newTestPool(t, func(cfg *config.Config) {
+		cfg.WorkerCount = 2
+	})
 
 	// Send 3 requests.
 	// Request 1 & 2 should take 500ms each.
@@ -35,7 +81,7 @@ func TestPoolConcurrency(t *testing.T) {
 	results := make(chan error, 3)
 
 	sendReq := func(sleepMs int) {
-		_, err := pool.HandleRequest(map[string]interface{}{"sleep": sleepMs})
+		_, err := pool.HandleRequest(&Request{Query: fmt.Sprintf("sleep=%d", sleepMs)}, nil)
 		results <- err
 	}
 
@@ -63,44 +109,350 @@ func TestPoolConcurrency(t *testing.T) {
 }
 
 func TestHeaderRelay(t *testing.T) {
-	cfg := config.DefaultConfig()
-	cfg.WorkerCount = 1
-	cfg.WorkerCommand = "test_worker.php"
-	cfg.ProjectRoot = "./"
+	pool := newTestPool(t, nil)
+
+	inputHeaders := map[string][]string{
+		"X-Single": {"val1"},
+		"X-Multi":  {"val1", "val2"},
+	}
+
+	resp, err := pool.HandleRequest(&Request{Headers: inputHeaders}, nil)
 
-	pool, err := NewPool(cfg)
 	if err != nil {
-		t.Fatalf("Failed to create pool: %v", err)
+		t.Fatalf("Request failed: %v", err)
 	}
 
-	if err := pool.Start(); err != nil {
-		t.Fatalf("Failed to start pool: %v", err)
+	// Check X-Multi
+	if multi := resp.Headers["X-Multi"]; len(multi) != 2 {
+		t.Errorf("X-Multi header lost values: %v", multi)
 	}
-	defer pool.Stop()
+}
 
-	time.Sleep(100 * time.Millisecond)
+func TestMsgpackBinaryBody(t *testing.T) {
+	requireMsgpack(t)
+	pool := newTestPool(t, func(cfg *config.Config) {
+		cfg.Protocol = ProtocolMsgpack
+	})
 
-	inputHeaders := map[string][]string{
-		"X-Single": {"val1"},
-		"X-Multi":  {"val1", "val2"},
+	// Invalid UTF-8 would be mangled by the JSON encoder
+	payload := []byte{0x00, 0xff, 0xfe, 0x80, '\n', 0x7f}
+
+	resp, err := pool.HandleRequest(&Request{Query: "echo=1"}, bytes.NewReader(payload))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+
+	if !bytes.Equal(resp.Body, payload) {
+		t.Errorf("Binary body mangled: got %v, want %v", resp.Body, payload)
+	}
+}
+
+func benchmarkProtocol(b *testing.B, protocol string) {
+	if protocol == ProtocolMsgpack {
+		requireMsgpack(b)
+	}
+	pool := newTestPool(b, func(cfg *config.Config) {
+		cfg.WorkerCount = 4
+		cfg.Protocol = protocol
+	})
+
+	body := bytes.Repeat([]byte("tusk"), 1024)
+	headers := map[string][]string{
+		"Accept":     {"text/html"},
+		"User-Agent": {"bench"},
 	}
 
-	resp, err := pool.HandleRequest(map[string]interface{}{
-		"headers": inputHeaders,
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			req := &Request{
+				Method:  "POST",
+				URI:     "/bench",
+				Headers: headers,
+			}
+			if _, err := pool.HandleRequest(req, bytes.NewReader(body)); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkProtocolNDJSON(b *testing.B) {
+	benchmarkProtocol(b, ProtocolNDJSON)
+}
+
+func BenchmarkProtocolMsgpack(b *testing.B) {
+	benchmarkProtocol(b, ProtocolMsgpack)
+}
+
+func TestStreamingBodies(t *testing.T) {
+	pool := newTestPool(t, func(cfg *config.Config) {
+		cfg.Streaming = true
 	})
 
+	// Request body larger than a single chunk frame
+	payload := bytes.Repeat([]byte("0123456789"), 10000)
+	resp, err := pool.HandleRequest(&Request{Query: "echo=1"}, bytes.NewReader(payload))
 	if err != nil {
 		t.Fatalf("Request failed: %v", err)
 	}
+	if !bytes.Equal(resp.Body, payload) {
+		t.Errorf("Streamed request body mismatch: got %d bytes, want %d", len(resp.Body), len(payload))
+	}
 
-	respHeaders, ok := resp["headers"].(map[string]interface{})
-	if !ok {
-		t.Fatalf("Invalid response headers format: %v", resp["headers"])
+	// Streamed response body
+	resp, err = pool.HandleRequest(&Request{Query: "chunks=3"}, nil)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	stream := resp.Stream
+	if stream == nil {
+		t.Fatalf("Expected streamed body, got %q", resp.Body)
+	}
+	data, err := io.ReadAll(stream)
+	stream.Close()
+	if err != nil {
+		t.Fatalf("Reading stream failed: %v", err)
+	}
+	if string(data) != "chunk0\nchunk1\nchunk2\n" {
+		t.Errorf("Unexpected streamed body %q", data)
 	}
 
-	// Check X-Multi
-	multi, ok := respHeaders["X-Multi"].([]interface{})
-	if !ok || len(multi) != 2 {
-		t.Errorf("X-Multi header lost values: %v", respHeaders["X-Multi"])
+	// The single worker must be back in the pool
+	done := make(chan error, 1)
+	go func() {
+		_, err := pool.HandleRequest(&Request{}, nil)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Follow-up request failed: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Worker was not returned to the pool after streaming")
+	}
+}
+
+func TestStreamingBinaryNDJSON(t *testing.T) {
+	pool := newTestPool(t, func(cfg *config.Config) {
+		cfg.Streaming = true
+	})
+
+	// Every byte value, across several chunk frames; raw JSON strings
+	// would replace the invalid UTF-8 sequences
+	payload := make([]byte, 3*chunkSize)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+
+	resp, err := pool.HandleRequest(&Request{Query: "chunks=1&echo=1"}, bytes.NewReader(payload))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if resp.Stream == nil {
+		t.Fatalf("Expected streamed body, got %q", resp.Body)
+	}
+	data, err := io.ReadAll(resp.Stream)
+	resp.Stream.Close()
+	if err != nil {
+		t.Fatalf("Reading stream failed: %v", err)
+	}
+	if !bytes.Equal(data, payload) {
+		t.Errorf("Binary body mangled: got %d bytes, want %d", len(data), len(payload))
+	}
+}
+
+func TestAbandonedStream(t *testing.T) {
+	pool := newTestPool(t, func(cfg *config.Config) {
+		cfg.Streaming = true
+	})
+	pid := pool.Status().Workers[0].PID
+
+	abandon := func(req *Request) {
+		t.Helper()
+		resp, err := pool.HandleRequest(req, nil)
+		if err != nil || resp.Stream == nil {
+			t.Fatalf("Expected a streamed response, got %v", err)
+		}
+		resp.Stream.Read(make([]byte, 64))
+		resp.Stream.Close()
+	}
+
+	// The rest of the response is drained and the worker reused
+	abandon(&Request{Query: "chunks=3"})
+	if _, err := pool.HandleRequest(&Request{}, nil); err != nil {
+		t.Fatalf("Request after an abandoned stream failed: %v", err)
+	}
+	if got := pool.Status().Workers[0].PID; got != pid {
+		t.Errorf("Worker was replaced after an abandoned response (pid %d -> %d)", pid, got)
+	}
+
+	// An event stream does not end on its own, so its worker is replaced
+	abandon(&Request{Query: "chunks=3", Headers: http.Header{"Content-Type": {"text/event-stream"}}})
+	if _, err := pool.HandleRequest(&Request{}, nil); err != nil {
+		t.Fatalf("Request after an abandoned event stream failed: %v", err)
+	}
+	if got := pool.Status().Workers[0].PID; got == pid {
+		t.Error("Worker serving an abandoned event stream was not replaced")
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	pool := newTestPool(t, func(cfg *config.Config) {
+		cfg.RequestTimeout = config.Duration(200 * time.Millisecond)
+	})
+
+	start := time.Now()
+	_, err := pool.HandleRequest(&Request{Query: "sleep=5000"}, nil)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Expected ErrTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Timeout took too long: %v", elapsed)
+	}
+
+	// The hung worker is killed and respawned, so the pool recovers
+	done := make(chan error, 1)
+	go func() {
+		_, err := pool.HandleRequest(&Request{}, nil)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Request after timeout failed: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Pool did not recover after worker timeout")
+	}
+}
+
+func TestMaxRequestsRecycling(t *testing.T) {
+	pool := newTestPool(t, func(cfg *config.Config) {
+		cfg.MaxRequests = 2
+	})
+
+	pool.mu.Lock()
+	original := pool.workers[0]
+	pool.mu.Unlock()
+
+	for i := 0; i < 3; i++ {
+		if _, err := pool.HandleRequest(&Request{}, nil); err != nil {
+			t.Fatalf("Request %d failed: %v", i, err)
+		}
+	}
+
+	if !original.retired.Load() {
+		t.Error("Worker was not retired after reaching max_requests")
+	}
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if len(pool.workers) != 1 || pool.workers[0] == original {
+		t.Errorf("Expected one fresh replacement worker, got %d workers", len(pool.workers))
+	}
+	if got := pool.workers[0].requests.Load(); got != 1 {
+		t.Errorf("Replacement should have served 1 request, got %d", got)
+	}
+
+	select {
+	case <-original.done:
+	case <-time.After(stopGrace + time.Second):
+		t.Error("Retired worker did not exit")
+	}
+}
+
+func TestProcessRSS(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("RSS is only read on Linux")
+	}
+
+	rss, err := processRSS(os.Getpid())
+	if err != nil {
+		t.Fatalf("processRSS failed: %v", err)
+	}
+	if rss == 0 {
+		t.Error("Expected a non-zero RSS for the test process")
+	}
+}
+
+func TestDynamicScaling(t *testing.T) {
+	pool := newTestPool(t, func(cfg *config.Config) {
+		cfg.MinWorkers = 1
+		cfg.MaxWorkers = 3
+		cfg.ScaleUpThreshold = config.Duration(20 * time.Millisecond)
+		cfg.IdleTimeout = config.Duration(300 * time.Millisecond)
+	})
+
+	size := func() int {
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+		return len(pool.workers)
+	}
+	if got := size(); got != 1 {
+		t.Fatalf("Expected pool to start with 1 worker, got %d", got)
+	}
+
+	// A burst of slow requests makes waiting requests grow the pool
+	results := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			_, err := pool.HandleRequest(&Request{Query: "sleep=500"}, nil)
+			results <- err
+		}()
+	}
+	for i := 0; i < 3; i++ {
+		if err := <-results; err != nil {
+			t.Errorf("Request %d failed: %v", i, err)
+		}
+	}
+	if got := size(); got != 3 {
+		t.Errorf("Expected pool to grow to 3 workers, got %d", got)
+	}
+
+	// Once idle, it shrinks back to the minimum
+	deadline := time.Now().Add(2 * time.Second)
+	for size() > 1 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if got := size(); got != 1 {
+		t.Errorf("Expected pool to shrink back to 1 worker, got %d", got)
+	}
+}
+
+func TestReload(t *testing.T) {
+	pool := newTestPool(t, func(cfg *config.Config) {
+		cfg.WorkerCount = 2
+	})
+
+	pool.mu.Lock()
+	originals := append([]*Process(nil), pool.workers...)
+	pool.mu.Unlock()
+
+	pool.Reload()
+
+	for i := 0; i < 4; i++ {
+		if _, err := pool.HandleRequest(&Request{}, nil); err != nil {
+			t.Fatalf("Request %d after reload failed: %v", i, err)
+		}
+	}
+
+	for _, w := range originals {
+		if !w.retired.Load() {
+			t.Errorf("Worker %d was not retired by reload", w.ID)
+		}
+	}
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if len(pool.workers) != 2 {
+		t.Fatalf("Expected 2 workers after reload, got %d", len(pool.workers))
+	}
+	for _, w := range pool.workers {
+		if w.gen != pool.gen.Load() {
+			t.Errorf("Worker %d is from generation %d, want %d", w.ID, w.gen, pool.gen.Load())
+		}
 	}
 }
@@ -0,0 +1,137 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tusk-framework/tusk-engine/internal/metrics"
+)
+
+// Requests shed under load. Both mean the engine is overloaded, not that
+// the request was bad; callers should answer 503 with Retry-After.
+var (
+	ErrQueueFull    = errors.New("request queue is full")
+	ErrQueueTimeout = errors.New("timed out waiting for a free worker")
+)
+
+// Reasons a request is shed (the "reason" label of tusk_requests_shed_total)
+const (
+	shedQueueFull    = "queue_full"
+	shedQueueTimeout = "queue_timeout"
+)
+
+type priorityKey struct{}
+
+// WithPriority marks requests made with ctx as high priority: they are
+// handed the next free worker before any other waiting request and are
+// never rejected because the queue is full
+func WithPriority(ctx context.Context) context.Context {
+	return context.WithValue(ctx, priorityKey{}, true)
+}
+
+func isPriority(ctx context.Context) bool {
+	priority, _ := ctx.Value(priorityKey{}).(bool)
+	return priority
+}
+
+// acquire takes an idle worker from the queue, blocking until one is free,
+// ctx is cancelled or the pool starts crash looping. Requests beyond
+// max_queue_length waiting ones, or waiting longer than max_queue_wait,
+// are shed. In dynamic mode a request that waits longer than
+// scale_up_threshold adds a worker, up to max_workers.
+func (p *Pool) acquire(ctx context.Context) (*Process, error) {
+	if err := ctx.Err(); err != nil {
+		metrics.RequestsCancelled.WithLabelValues(cancelQueue).Inc()
+		return nil, err
+	}
+	select {
+	case w := <-p.workerQueue:
+		if p.current(w) {
+			return w, nil
+		}
+	default:
+	}
+
+	priority := isPriority(ctx)
+	waiting := p.waiting.Add(1)
+	metrics.RequestsWaiting.Inc()
+	defer func() {
+		p.waiting.Add(-1)
+		metrics.RequestsWaiting.Dec()
+	}()
+
+	if limit := p.cfg.MaxQueueLength; limit > 0 && waiting > int64(limit) && !priority {
+		metrics.RequestsShed.WithLabelValues(shedQueueFull).Inc()
+		return nil, fmt.Errorf("%w (%d waiting)", ErrQueueFull, limit)
+	}
+
+	var expired <-chan time.Time
+	if wait := time.Duration(p.cfg.MaxQueueWait); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	var scaleUp <-chan time.Time
+	if p.dynamic() {
+		threshold := time.Duration(p.cfg.ScaleUpThreshold)
+		if threshold <= 0 {
+			threshold = defaultScaleUpThreshold
+		}
+		ticker := time.NewTicker(threshold)
+		defer ticker.Stop()
+		scaleUp = ticker.C
+	}
+
+	// Priority requests also take workers handed over by other waiters
+	var handoff chan *Process
+	if priority {
+		handoff = p.handoff
+		p.priorityWaiting.Add(1)
+		defer p.priorityWaiting.Add(-1)
+	}
+
+	for {
+		select {
+		case w := <-p.workerQueue:
+			if !p.current(w) {
+				continue
+			}
+			if priority || !p.handOver(w) {
+				return w, nil
+			}
+		case w := <-handoff:
+			return w, nil
+		case <-scaleUp:
+			p.scaleUp()
+		case <-p.crashSignal():
+			if loop := p.crashLoop(); loop != nil {
+				return nil, loop
+			}
+		case <-expired:
+			metrics.RequestsShed.WithLabelValues(shedQueueTimeout).Inc()
+			return nil, fmt.Errorf("%w after %s", ErrQueueTimeout, time.Duration(p.cfg.MaxQueueWait))
+		case <-ctx.Done():
+			metrics.RequestsCancelled.WithLabelValues(cancelQueue).Inc()
+			return nil, ctx.Err()
+		case <-p.ctx.Done():
+			return nil, fmt.Errorf("pool shutting down")
+		}
+	}
+}
+
+// handOver gives a worker taken by a regular request to a waiting priority
+// request instead, reporting whether one took it
+func (p *Pool) handOver(w *Process) bool {
+	if p.priorityWaiting.Load() == 0 {
+		return false
+	}
+	select {
+	case p.handoff <- w:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,122 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tusk-framework/tusk-engine/internal/config"
+	"github.com/tusk-framework/tusk-engine/internal/metrics"
+)
+
+// queueConfig bounds the request queue
+func queueConfig(length int, wait time.Duration) func(*config.Config) {
+	return func(cfg *config.Config) {
+		cfg.MaxQueueLength = length
+		cfg.MaxQueueWait = config.Duration(wait)
+	}
+}
+
+// waitQueued blocks until n requests are waiting for a worker
+func waitQueued(t *testing.T, pool *Pool, n int64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for pool.waiting.Load() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d waiting requests, got %d", n, pool.waiting.Load())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestQueueShedding(t *testing.T) {
+	pool := newTestPool(t, queueConfig(1, 0))
+
+	busy := make(chan error, 1)
+	go func() {
+		_, err := pool.HandleRequest(&Request{Query: "sleep=300"}, nil)
+		busy <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	queued := make(chan error, 1)
+	go func() {
+		_, err := pool.HandleRequest(&Request{}, nil)
+		queued <- err
+	}()
+	waitQueued(t, pool, 1)
+
+	shed := testutil.ToFloat64(metrics.RequestsShed.WithLabelValues(shedQueueFull))
+	if _, err := pool.HandleRequest(&Request{}, nil); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("expected ErrQueueFull, got %v", err)
+	}
+	if got := testutil.ToFloat64(metrics.RequestsShed.WithLabelValues(shedQueueFull)) - shed; got != 1 {
+		t.Errorf("expected 1 shed request to be counted, got %v", got)
+	}
+
+	// Priority requests are queued even when the queue is full
+	if _, err := pool.HandleRequestContext(WithPriority(context.Background()), &Request{}, nil); err != nil {
+		t.Errorf("priority request failed: %v", err)
+	}
+
+	for _, ch := range []chan error{busy, queued} {
+		if err := <-ch; err != nil {
+			t.Errorf("queued request failed: %v", err)
+		}
+	}
+}
+
+func TestQueueTimeout(t *testing.T) {
+	pool := newTestPool(t, queueConfig(0, 50*time.Millisecond))
+
+	busy := make(chan error, 1)
+	go func() {
+		_, err := pool.HandleRequest(&Request{Query: "sleep=300"}, nil)
+		busy <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	_, err := pool.HandleRequest(&Request{}, nil)
+	if !errors.Is(err, ErrQueueTimeout) {
+		t.Fatalf("expected ErrQueueTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("request was shed after %s, expected about 50ms", elapsed)
+	}
+	if err := <-busy; err != nil {
+		t.Errorf("busy request failed: %v", err)
+	}
+}
+
+func TestPriorityJumpsQueue(t *testing.T) {
+	pool := newTestPool(t, queueConfig(0, 0))
+
+	done := make(chan string, 3)
+	go func() {
+		pool.HandleRequest(&Request{Query: "sleep=200"}, nil)
+		done <- "busy"
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	go func() {
+		pool.HandleRequest(&Request{}, nil)
+		done <- "regular"
+	}()
+	waitQueued(t, pool, 1)
+	go func() {
+		pool.HandleRequestContext(WithPriority(context.Background()), &Request{Query: "sleep=50"}, nil)
+		done <- "priority"
+	}()
+	waitQueued(t, pool, 2)
+
+	if first := <-done; first != "busy" {
+		t.Fatalf("expected the busy request to finish first, got %s", first)
+	}
+	if next := <-done; next != "priority" {
+		t.Errorf("expected the priority request to be served next, got %s", next)
+	}
+	<-done
+}
@@ -0,0 +1,102 @@
+package worker
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/tusk-framework/tusk-engine/internal/metrics"
+)
+
+// Reasons a worker is recycled (the "reason" label of tusk_worker_recycles_total)
+const (
+	recycleMaxRequests = "max_requests"
+	recycleMaxLifetime = "max_lifetime"
+	recycleMaxMemory   = "max_memory"
+	recycleReload      = "reload"
+)
+
+// stopGrace is how long a retired worker gets to exit after its stdin is closed
+const stopGrace = 5 * time.Second
+
+// recycleReason reports why a worker that just finished a request should be
+// retired, or "" if it can keep serving
+func (p *Pool) recycleReason(w *Process) string {
+	if w.gen != p.gen.Load() {
+		return recycleReload
+	}
+	if p.cfg.MaxRequests > 0 && w.requests.Load() >= int64(p.cfg.MaxRequests) {
+		return recycleMaxRequests
+	}
+	if p.cfg.MaxLifetime > 0 && time.Since(w.CreatedAt) >= time.Duration(p.cfg.MaxLifetime) {
+		return recycleMaxLifetime
+	}
+	if p.cfg.MaxMemoryMB > 0 && w.cmd.Process != nil {
+		rss, err := processRSS(w.cmd.Process.Pid)
+		if err == nil && rss >= uint64(p.cfg.MaxMemoryMB)<<20 {
+			return recycleMaxMemory
+		}
+	}
+	return ""
+}
+
+// retire replaces an idle, checked-out worker with a fresh process and then
+// shuts the old one down. The replacement is spawned first so the pool keeps
+// its capacity.
+func (p *Pool) retire(w *Process, reason string) {
+	w.retired.Store(true)
+	metrics.WorkerRecycles.WithLabelValues(reason).Inc()
+	slog.Info("Recycling worker", "worker", w.ID, "requests", w.requests.Load(), "reason", reason)
+
+	p.mu.Lock()
+	err := p.spawnWorker(w.ID)
+	if err == nil {
+		p.removeWorker(w)
+	}
+	p.mu.Unlock()
+
+	if err != nil {
+		slog.Error("Failed to spawn replacement worker", "worker", w.ID, "err", err)
+		go p.respawn(w, true)
+	}
+	go w.stop()
+}
+
+// stop asks the worker to exit by closing its stdin, killing it if it does
+// not leave within the grace period
+func (w *Process) stop() {
+	w.Stdin.Close()
+
+	select {
+	case <-w.done:
+	case <-time.After(stopGrace):
+		w.kill()
+	}
+}
+
+// Reload performs a rolling restart of every worker, e.g. after the PHP
+// code changed. Idle workers are replaced right away; busy ones finish their
+// current request first, so no in-flight request is dropped. It returns the
+// new pool generation.
+func (p *Pool) Reload() uint64 {
+	gen := p.gen.Add(1)
+	slog.Info("Reloading workers", "generation", gen)
+
+	// New code deserves a fresh start
+	p.resetCrashes()
+
+	for i := len(p.workerQueue); i > 0; i-- {
+		var w *Process
+		select {
+		case w = <-p.workerQueue:
+		default:
+			return gen
+		}
+
+		if w.gen != gen {
+			p.retire(w, recycleReload)
+		} else {
+			p.workerQueue <- w
+		}
+	}
+	return gen
+}
@@ -0,0 +1,41 @@
+package worker
+
+import (
+	"io"
+	"net/http"
+)
+
+// brokenError marks a failed exchange with a worker that died or whose
+// pipes broke. The request never got an answer, so idempotent requests
+// may be retried on another worker.
+type brokenError struct {
+	err error
+}
+
+func (e *brokenError) Error() string { return e.err.Error() }
+func (e *brokenError) Unwrap() error { return e.err }
+
+// idempotent reports whether a request is safe to send twice: GET, HEAD
+// and OPTIONS requests, and ones carrying an Idempotency-Key header
+func idempotent(req *Request) bool {
+	if req.Type != "" {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return req.Headers.Get("Idempotency-Key") != ""
+}
+
+// readCounter counts the bytes read from a streamed request body
+type readCounter struct {
+	r io.Reader
+	n int64
+}
+
+func (c *readCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,83 @@
+package worker
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tusk-framework/tusk-engine/internal/config"
+	"github.com/tusk-framework/tusk-engine/internal/metrics"
+)
+
+func TestIdempotent(t *testing.T) {
+	tests := []struct {
+		req  *Request
+		want bool
+	}{
+		{&Request{Method: "GET"}, true},
+		{&Request{Method: "HEAD"}, true},
+		{&Request{Method: "OPTIONS"}, true},
+		{&Request{Method: "POST"}, false},
+		{&Request{Method: "POST", Headers: http.Header{"Idempotency-Key": {"abc"}}}, true},
+		{&Request{Method: "PUT", Headers: http.Header{"Idempotency-Key": {"abc"}}}, true},
+		{&Request{Type: "ws.message"}, false},
+	}
+
+	for _, tt := range tests {
+		if got := idempotent(tt.req); got != tt.want {
+			t.Errorf("idempotent(%v) = %v, want %v", tt.req, got, tt.want)
+		}
+	}
+}
+
+func TestRetryOnWorkerDeath(t *testing.T) {
+	requirePHP(t)
+
+	for _, streaming := range []bool{false, true} {
+		t.Run(fmt.Sprintf("streaming=%v", streaming), func(t *testing.T) {
+			pool := newTestPool(t, func(cfg *config.Config) {
+				cfg.WorkerCount = 2
+				cfg.Streaming = streaming
+				cfg.RestartBackoff = config.Duration(20 * time.Millisecond)
+			})
+
+			dir := t.TempDir()
+			send := func(name string, req *Request) error {
+				req.Query = url.Values{"die_once": {filepath.Join(dir, name)}}.Encode()
+				_, err := pool.HandleRequest(req, nil)
+				return err
+			}
+
+			retries := testutil.ToFloat64(metrics.RequestRetries)
+			if err := send("get", &Request{Method: "GET"}); err != nil {
+				t.Errorf("GET was not retried: %v", err)
+			}
+			if got := testutil.ToFloat64(metrics.RequestRetries) - retries; got != 1 {
+				t.Errorf("expected 1 retry to be counted, got %v", got)
+			}
+
+			keyed := &Request{
+				Method:  "POST",
+				Headers: http.Header{"Idempotency-Key": {"k1"}},
+			}
+			if err := send("keyed", keyed); err != nil {
+				t.Errorf("POST with Idempotency-Key was not retried: %v", err)
+			}
+
+			if err := send("post", &Request{Method: "POST"}); err == nil {
+				t.Error("expected a POST whose worker died to fail")
+			}
+
+			// The dead worker is never handed out again
+			for i := 0; i < 4; i++ {
+				if _, err := pool.HandleRequest(&Request{Method: "POST"}, nil); err != nil {
+					t.Fatalf("request %d after worker death failed: %v", i, err)
+				}
+			}
+		})
+	}
+}
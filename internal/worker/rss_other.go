@@ -0,0 +1,10 @@
+//go:build !linux
+
+package worker
+
+import "errors"
+
+// processRSS is only implemented on Linux; max_memory_mb is ignored elsewhere
+func processRSS(pid int) (uint64, error) {
+	return 0, errors.New("reading process memory is not supported on this platform")
+}
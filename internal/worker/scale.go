@@ -0,0 +1,125 @@
+package worker
+
+import (
+	"log/slog"
+	"time"
+)
+
+// defaultScaleUpThreshold applies when scale_up_threshold is not positive
+const defaultScaleUpThreshold = 100 * time.Millisecond
+
+// dynamic reports whether the pool scales between min_workers and max_workers
+func (p *Pool) dynamic() bool {
+	return p.cfg.MaxWorkers > 0
+}
+
+// minWorkers is the number of workers the pool never shrinks below
+func (p *Pool) minWorkers() int {
+	if !p.dynamic() {
+		return p.cfg.WorkerCount
+	}
+	if p.cfg.MinWorkers < 1 {
+		return 1
+	}
+	if p.cfg.MinWorkers > p.cfg.MaxWorkers {
+		return p.cfg.MaxWorkers
+	}
+	return p.cfg.MinWorkers
+}
+
+// maxWorkers is the number of workers the pool never grows beyond
+func (p *Pool) maxWorkers() int {
+	if !p.dynamic() {
+		return p.cfg.WorkerCount
+	}
+	return p.cfg.MaxWorkers
+}
+
+// current reports whether a worker taken from the queue belongs to the
+// current generation. Workers left over from before a Reload are retired;
+// ones that died or were killed while idle are dropped, watchWorker
+// replaces them.
+func (p *Pool) current(w *Process) bool {
+	if w.killed.Load() {
+		return false
+	}
+	select {
+	case <-w.done:
+		return false
+	default:
+	}
+	if w.gen == p.gen.Load() {
+		return true
+	}
+	p.retire(w, recycleReload)
+	return false
+}
+
+// scaleUp adds a worker if the pool is below max_workers
+func (p *Pool) scaleUp() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.workers) >= p.maxWorkers() {
+		return
+	}
+
+	id := p.nextID
+	p.nextID++
+	if err := p.spawnWorker(id); err != nil {
+		slog.Error("Failed to scale up", "err", err)
+		return
+	}
+	slog.Info("Scaled up", "workers", len(p.workers))
+}
+
+// monitor periodically retires workers that have been idle for longer than
+// idle_timeout while the pool is above min_workers
+func (p *Pool) monitor() {
+	idleTimeout := time.Duration(p.cfg.IdleTimeout)
+	if idleTimeout <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(idleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.scaleDown(idleTimeout)
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+// scaleDown briefly takes every idle worker out of the queue, retiring the
+// ones idle for too long and putting the rest back
+func (p *Pool) scaleDown(idleTimeout time.Duration) {
+	for i := len(p.workerQueue); i > 0; i-- {
+		var w *Process
+		select {
+		case w = <-p.workerQueue:
+		default:
+			return
+		}
+
+		p.mu.Lock()
+		shrink := len(p.workers) > p.minWorkers() && time.Since(w.lastUsed()) >= idleTimeout
+		if shrink {
+			w.retired.Store(true)
+			p.removeWorker(w)
+		}
+		size := len(p.workers)
+		p.mu.Unlock()
+
+		if !shrink {
+			p.workerQueue <- w
+			continue
+		}
+
+		slog.Info("Worker idle, scaled down", "worker", w.ID, "workers", size)
+		go w.stop()
+	}
+}
@@ -0,0 +1,107 @@
+package worker
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/tusk-framework/tusk-engine/internal/metrics"
+)
+
+// maxStderrLine bounds a single buffered stderr line
+const maxStderrLine = 1 << 20
+
+// phpError matches the messages PHP writes for errors, with the "PHP "
+// prefix used when log_errors goes to stderr
+var phpError = regexp.MustCompile(`^(?:PHP )?(Fatal error|Parse error|Recoverable fatal error|Warning|Notice|Deprecated|Strict Standards)\s*:\s*(.*)$`)
+
+// phpLevels maps PHP error prefixes to the "level" label of
+// tusk_php_errors_total and the log level they are reported at
+var phpLevels = map[string]struct {
+	label string
+	level slog.Level
+}{
+	"Fatal error":             {"fatal", slog.LevelError},
+	"Parse error":             {"parse", slog.LevelError},
+	"Recoverable fatal error": {"fatal", slog.LevelError},
+	"Warning":                 {"warning", slog.LevelWarn},
+	"Notice":                  {"notice", slog.LevelInfo},
+	"Deprecated":              {"deprecated", slog.LevelInfo},
+	"Strict Standards":        {"deprecated", slog.LevelInfo},
+}
+
+// parsePHPError extracts the error level label and message from a stderr
+// line, reporting false for lines that are not PHP errors
+func parsePHPError(line string) (label string, level slog.Level, msg string, ok bool) {
+	m := phpError.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil {
+		return "", slog.LevelInfo, line, false
+	}
+	l := phpLevels[m[1]]
+	return l.label, l.level, m[2], true
+}
+
+// readStderr logs a worker's stderr line by line, tagged with the worker
+// and the request it was serving at the time
+func (p *Pool) readStderr(w *Process, stderr io.ReadCloser) {
+	defer close(w.stderrDone)
+	defer stderr.Close()
+
+	scanner := bufio.NewScanner(stderr)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxStderrLine)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		w.stderrTail.add(line)
+
+		attrs := []any{"worker", w.ID, "pid", w.cmd.Process.Pid}
+		if id := w.currentRequest(); id != "" {
+			attrs = append(attrs, "request_id", id)
+		}
+
+		label, level, msg, ok := parsePHPError(line)
+		if !ok {
+			slog.Info("Worker output", append(attrs, "line", line)...)
+			continue
+		}
+		metrics.PHPErrors.WithLabelValues(label).Inc()
+		slog.Log(p.ctx, level, "PHP "+label, append(attrs, "message", msg)...)
+	}
+
+	if err := scanner.Err(); err != nil {
+		slog.Warn("Dropping worker stderr", "worker", w.ID, "err", err)
+		io.Copy(io.Discard, stderr) // Keep draining so the worker never blocks
+	}
+}
+
+// stderrTailLines is how many stderr lines are kept per worker for crash
+// reports
+const stderrTailLines = 20
+
+// lineTail keeps the last stderrTailLines lines written to it
+type lineTail struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (t *lineTail) add(line string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.lines) == stderrTailLines {
+		t.lines = append(t.lines[:0], t.lines[1:]...)
+	}
+	t.lines = append(t.lines, line)
+}
+
+// snapshot returns a copy of the kept lines
+func (t *lineTail) snapshot() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string(nil), t.lines...)
+}
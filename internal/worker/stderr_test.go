@@ -0,0 +1,96 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tusk-framework/tusk-engine/internal/metrics"
+)
+
+func TestParsePHPError(t *testing.T) {
+	tests := []struct {
+		line, label, msg string
+		level            slog.Level
+		ok               bool
+	}{
+		{"PHP Fatal error:  Uncaught Exception: boom in /app/index.php:3", "fatal", "Uncaught Exception: boom in /app/index.php:3", slog.LevelError, true},
+		{"PHP Parse error:  syntax error, unexpected '}'", "parse", "syntax error, unexpected '}'", slog.LevelError, true},
+		{"PHP Warning:  Undefined variable $x in /app/index.php on line 3", "warning", "Undefined variable $x in /app/index.php on line 3", slog.LevelWarn, true},
+		{"Notice: Only variables should be passed by reference", "notice", "Only variables should be passed by reference", slog.LevelInfo, true},
+		{"PHP Deprecated:  strftime() is deprecated", "deprecated", "strftime() is deprecated", slog.LevelInfo, true},
+		{"debug output from var_dump", "", "debug output from var_dump", slog.LevelInfo, false},
+	}
+
+	for _, tt := range tests {
+		label, level, msg, ok := parsePHPError(tt.line)
+		if label != tt.label || level != tt.level || msg != tt.msg || ok != tt.ok {
+			t.Errorf("parsePHPError(%q) = %q, %v, %q, %v", tt.line, label, level, msg, ok)
+		}
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent log writes
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestStderrLogging(t *testing.T) {
+	requirePHP(t)
+
+	logs := &syncBuffer{}
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewJSONHandler(logs, nil)))
+
+	pool := newTestPool(t, nil)
+
+	warnings := metrics.PHPErrors.WithLabelValues("warning")
+	before := testutil.ToFloat64(warnings)
+
+	ctx := WithDispatch(context.Background(), &Dispatch{RequestID: "req-42"})
+	req := &Request{Query: url.Values{"stderr": {"PHP Warning:  Undefined variable $x in /app/index.php on line 3"}}.Encode()}
+	if _, err := pool.HandleRequestContext(ctx, req, nil); err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for testutil.ToFloat64(warnings) == before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := testutil.ToFloat64(warnings) - before; got != 1 {
+		t.Fatalf("Expected 1 PHP warning, got %v", got)
+	}
+
+	var record map[string]interface{}
+	for _, line := range strings.Split(logs.String(), "\n") {
+		if strings.Contains(line, "Undefined variable") {
+			json.Unmarshal([]byte(line), &record)
+		}
+	}
+	if record == nil {
+		t.Fatalf("Warning was not logged:\n%s", logs.String())
+	}
+	if record["level"] != "WARN" || record["request_id"] != "req-42" || record["worker"] != float64(0) {
+		t.Errorf("Unexpected log record %v", record)
+	}
+}
@@ -0,0 +1,197 @@
+package worker
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Frame types used when bodies are streamed between the engine and a worker.
+// A streamed request is followed by chunk frames and an end frame; a worker
+// may answer with a headers frame followed by chunk frames and an end frame.
+const (
+	frameHeaders = "headers"
+	frameChunk   = "chunk"
+	frameEnd     = "end"
+)
+
+// Health check frames: the engine sends a ping to an idle worker, which
+// answers with a pong
+const (
+	framePing = "ping"
+	framePong = "pong"
+)
+
+// chunkSize is the maximum amount of body data sent in a single frame
+const chunkSize = 32 * 1024
+
+// EncodingBase64 marks chunk frames and WebSocket data that are base64
+// encoded. JSON strings cannot carry arbitrary bytes, so over ndjson the
+// engine encodes every chunk and binary message it sends; workers do the
+// same for binary response chunks and messages.
+const EncodingBase64 = "base64"
+
+// Limits for draining the rest of an abandoned response, see Close
+const (
+	drainLimit   = 1024 * 1024
+	drainTimeout = 5 * time.Second
+)
+
+// sendBody streams the request body to the worker as chunk frames
+func sendBody(w *Process, protocol string, body io.Reader) error {
+	if body != nil {
+		buf := make([]byte, chunkSize)
+		for {
+			n, err := body.Read(buf)
+			if n > 0 {
+				frame := map[string]interface{}{"type": frameChunk, "data": string(buf[:n])}
+				if protocol != ProtocolMsgpack {
+					frame["data"] = base64.StdEncoding.EncodeToString(buf[:n])
+					frame["encoding"] = EncodingBase64
+				}
+				if err := w.Codec.Encode(frame); err != nil {
+					return &brokenError{fmt.Errorf("worker %d encode error: %w", w.ID, err)}
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read request body: %w", err)
+			}
+		}
+	}
+
+	if err := w.Codec.Encode(map[string]interface{}{"type": frameEnd}); err != nil {
+		return &brokenError{fmt.Errorf("worker %d encode error: %w", w.ID, err)}
+	}
+	return nil
+}
+
+// bodyStream reads a streamed response body from a worker. The worker stays
+// checked out of the pool until the end frame is read or the stream is closed.
+type bodyStream struct {
+	pool    *Pool
+	w       *Process
+	bounded bool // the response ends on its own, unlike Server-Sent Events
+	buf     []byte
+	err     error
+	once    sync.Once
+}
+
+func newBodyStream(p *Pool, w *Process, resp *Response) *bodyStream {
+	sse := strings.HasPrefix(resp.Headers.Get("Content-Type"), "text/event-stream")
+	return &bodyStream{pool: p, w: w, bounded: !sse}
+}
+
+// Read returns body data one frame at a time, so callers that flush after
+// every Read forward each chunk as soon as the worker emits it
+func (s *bodyStream) Read(b []byte) (int, error) {
+	for len(s.buf) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+
+		var frame map[string]interface{}
+		if err := s.w.Codec.Decode(&frame); err != nil {
+			s.fail(fmt.Errorf("worker %d decode error: %w", s.w.ID, err))
+			return 0, s.err
+		}
+
+		switch frame["type"] {
+		case frameChunk:
+			data, err := chunkData(frame)
+			if err != nil {
+				s.fail(fmt.Errorf("worker %d sent an invalid chunk: %w", s.w.ID, err))
+				return 0, s.err
+			}
+			s.buf = data
+		case frameEnd:
+			s.err = io.EOF
+			s.once.Do(func() { s.pool.finish(s.w) })
+		default:
+			s.fail(fmt.Errorf("worker %d sent unexpected frame %v while streaming", s.w.ID, frame["type"]))
+		}
+	}
+
+	n := copy(b, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+// Close releases the worker. The rest of a response abandoned mid-stream
+// (e.g. the client went away) is drained in the background so the worker
+// can be reused. A worker that cannot be resynchronized that way, because
+// it serves Server-Sent Events or its response exceeds drainLimit or
+// drainTimeout, is killed and replaced.
+func (s *bodyStream) Close() error {
+	if s.err != nil {
+		return nil
+	}
+	s.err = io.ErrClosedPipe
+	if !s.bounded {
+		s.abort()
+		return nil
+	}
+	go s.drain()
+	return nil
+}
+
+// drain discards frames up to the end frame and then hands the worker back
+func (s *bodyStream) drain() {
+	timer := time.AfterFunc(drainTimeout, s.w.kill)
+	defer timer.Stop()
+
+	for n := 0; n <= drainLimit; {
+		var frame map[string]interface{}
+		if err := s.w.Codec.Decode(&frame); err != nil {
+			break
+		}
+		if frame["type"] == frameEnd {
+			// A timer that already fired may still kill the worker, so
+			// it can only be handed back once the timer is disarmed
+			if !timer.Stop() {
+				break
+			}
+			s.once.Do(func() { s.pool.finish(s.w) })
+			return
+		}
+		data, err := chunkData(frame)
+		if frame["type"] != frameChunk || err != nil {
+			break
+		}
+		n += len(data)
+	}
+	s.abort()
+}
+
+// fail aborts the stream with err
+func (s *bodyStream) fail(err error) {
+	s.err = err
+	s.abort()
+}
+
+// abort kills the worker, which is left mid-response
+func (s *bodyStream) abort() {
+	s.once.Do(func() {
+		s.w.kill()
+		s.pool.release()
+	})
+}
+
+// chunkData returns the bytes carried by a decoded chunk frame
+func chunkData(frame map[string]interface{}) ([]byte, error) {
+	switch data := frame["data"].(type) {
+	case string:
+		if frame["encoding"] == EncodingBase64 {
+			return base64.StdEncoding.DecodeString(data)
+		}
+		return []byte(data), nil
+	case []byte:
+		return data, nil
+	}
+	return nil, nil
+}
@@ -1,19 +1,191 @@
 <?php
 stream_set_write_buffer(STDOUT, 0);
+$msgpack = getenv('TUSK_PROTOCOL') === 'msgpack';
+
+function read_message($msgpack)
+{
+    if (!$msgpack) {
+        if (($line = fgets(STDIN)) === false)
+            return null;
+        return json_decode($line, true);
+    }
+    if (($prefix = fread(STDIN, 4)) === false || strlen($prefix) < 4)
+        return null;
+    $length = unpack('N', $prefix)[1];
+    $payload = '';
+    while (strlen($payload) < $length) {
+        $chunk = fread(STDIN, $length - strlen($payload));
+        if ($chunk === false || $chunk === '')
+            return null;
+        $payload .= $chunk;
+    }
+    return msgpack_unpack($payload);
+}
+
+function write_message($msgpack, $message)
+{
+    if (!$msgpack) {
+        echo json_encode($message) . "\n";
+        return;
+    }
+    $payload = msgpack_pack($message);
+    fwrite(STDOUT, pack('N', strlen($payload)) . $payload);
+}
+
+// cancelled reports whether the engine sent a cancel frame
+function cancelled($msgpack)
+{
+    $read = [STDIN];
+    $write = $except = null;
+    if (!stream_select($read, $write, $except, 0))
+        return false;
+    return (read_message($msgpack)['type'] ?? '') === 'cancel';
+}
+
+// TUSK_TEST_BOOT=crash simulates a worker script that does not compile
+if (getenv('TUSK_TEST_BOOT') === 'crash') {
+    fwrite(STDERR, "PHP Parse error:  syntax error, unexpected end of file in /app/worker.php on line 3\n");
+    exit(255);
+}
+
+// Ready handshake; TUSK_TEST_BOOT simulates a "slow" boot, a worker that
+// "never" gets ready or one speaking a "future" protocol version
+if (getenv('TUSK_HANDSHAKE')) {
+    $boot = getenv('TUSK_TEST_BOOT') ?: '';
+    if ($boot === 'slow')
+        usleep(300 * 1000);
+    if ($boot !== 'never')
+        write_message($msgpack, [
+            'type' => 'ready',
+            'protocol_version' => $boot === 'future' ? 99 : 1,
+            'capabilities' => ['streaming', 'ws', 'cancel'],
+        ]);
+}
+
+$mute = false;
 while (true) {
-    if (($line = fgets(STDIN)) === false)
+    if (($req = read_message($msgpack)) === null)
         break;
-    $req = json_decode($line, true);
+
+    // Health checks; a request with "mute" makes the worker ignore them
+    if (($req['type'] ?? '') === 'ping') {
+        if (!$mute)
+            write_message($msgpack, ['type' => 'pong']);
+        continue;
+    }
+    // Cancellations arriving after the response was sent are ignored
+    if (($req['type'] ?? '') === 'cancel')
+        continue;
+
+    // Tests control the worker through the query string, e.g. "sleep=300"
+    parse_str($req['query'] ?? '', $test);
+    if (!empty($test['mute']))
+        $mute = true;
+
+    // Streamed request bodies arrive as chunk frames terminated by an end
+    // frame; over ndjson their data is base64 encoded
+    if (!empty($req['stream'])) {
+        $req['body'] = '';
+        while (($frame = read_message($msgpack)) !== null && $frame['type'] === 'chunk')
+            $req['body'] .= ($frame['encoding'] ?? '') === 'base64' ? base64_decode($frame['data']) : $frame['data'];
+    }
+
+    // WebSocket events: echo messages (binary ones as they came, base64
+    // encoded over ndjson), broadcast "broadcast:..." to the lobby, close on
+    // "close:<code>" and announce departures; URLs containing "deny" are
+    // rejected
+    switch ($req['type'] ?? '') {
+        case 'ws.open':
+            if (strpos($req['url'] ?? '', 'deny') !== false) {
+                write_message($msgpack, ['status' => 403, 'headers' => [], 'body' => '']);
+            } else {
+                write_message($msgpack, ['status' => 200, 'ws' => [['action' => 'join', 'channel' => 'lobby']]]);
+            }
+            continue 2;
+        case 'ws.message':
+            $data = $req['data'] ?? '';
+            if (!empty($req['binary'])) {
+                $action = ['action' => 'send', 'data' => $data, 'binary' => true];
+                if (isset($req['encoding']))
+                    $action['encoding'] = $req['encoding'];
+            } elseif (strpos($data, 'close:') === 0) {
+                $action = ['action' => 'close', 'code' => (int) substr($data, 6)];
+            } else {
+                $action = strpos($data, 'broadcast:') === 0
+                    ? ['action' => 'broadcast', 'channel' => 'lobby', 'data' => substr($data, 10)]
+                    : ['action' => 'send', 'data' => 'echo:' . $data];
+            }
+            write_message($msgpack, ['status' => 200, 'ws' => [$action]]);
+            continue 2;
+        case 'ws.close':
+            write_message($msgpack, ['status' => 200, 'ws' => [['action' => 'broadcast', 'channel' => 'lobby', 'data' => 'left']]]);
+            continue 2;
+    }
+
+    // Crash mid-request the first time a marker file is seen
+    if (isset($test['die_once']) && !file_exists($test['die_once'])) {
+        touch($test['die_once']);
+        exit(1);
+    }
+
+    if (isset($test['stderr'])) {
+        fwrite(STDERR, $test['stderr'] . "\n");
+    }
+
+    // A "cancellable" sleep stops early when the engine cancels the request
+    if (isset($test['sleep']) && !empty($test['cancellable'])) {
+        $until = microtime(true) + $test['sleep'] / 1000;
+        while (microtime(true) < $until) {
+            if (cancelled($msgpack)) {
+                write_message($msgpack, ['type' => 'cancelled']);
+                continue 2;
+            }
+            usleep(10 * 1000);
+        }
+    } elseif (isset($test['sleep'])) {
+        usleep($test['sleep'] * 1000);
+    }
+
+    // Streamed response: headers frame, chunk frames, end frame. With
+    // "echo" the request body is streamed back, base64 encoded over ndjson.
+    if (isset($test['chunks'])) {
+        write_message($msgpack, ['type' => 'headers', 'status' => 200, 'headers' => $req['headers'] ?? []]);
+        if (!empty($test['echo'])) {
+            write_message($msgpack, $msgpack
+                ? ['type' => 'chunk', 'data' => $req['body'] ?? '']
+                : ['type' => 'chunk', 'data' => base64_encode($req['body'] ?? ''), 'encoding' => 'base64']);
+        } else {
+            for ($i = 0; $i < $test['chunks']; $i++)
+                write_message($msgpack, ['type' => 'chunk', 'data' => "chunk$i\n"]);
+        }
+        write_message($msgpack, ['type' => 'end']);
+        continue;
+    }
+
+    $headers = $req['headers'] ?? [];
+    if (isset($req['protocol']))
+        $headers['X-Protocol'] = $req['protocol'];
+    if (isset($req['traceparent']))
+        $headers['X-Traceparent'] = $req['traceparent'];
 
     $response = [
         'status' => 200,
-        'headers' => $req['headers'] ?? [],
-        'body' => 'ok'
+        'headers' => $headers,
+        'body' => !empty($test['echo']) ? ($req['body'] ?? '') : 'ok'
     ];
 
-    if (isset($req['sleep'])) {
-        usleep($req['sleep'] * 1000);
+    // Responses breaking the protocol: "invalid=status", "invalid=version"...
+    switch ($test['invalid'] ?? '') {
+        case 'status':
+            $response['status'] = 'ok';
+            break;
+        case 'version':
+            $response['protocol_version'] = 99;
+            break;
+        case 'headers':
+            $response['headers'] = ['X-Nested' => ['a' => ['b']]];
+            break;
     }
 
-    echo json_encode($response) . "\n";
+    write_message($msgpack, $response);
 }
@@ -2,11 +2,59 @@
 
 // Tusk Native Engine - Worker Script
 // This script runs in a loop, reading requests from STDIN and writing responses to STDOUT.
-// Protocol: NDJSON (Newline Delimited JSON)
+// Protocol: NDJSON (Newline Delimited JSON), see protocol.schema.json
 
 // Unbuffer stdout to ensure Go receives data immediately
 stream_set_write_buffer(STDOUT, 0);
 
+function tusk_send($message)
+{
+    fwrite(STDOUT, json_encode($message) . "\n");
+}
+
+// With "streaming": true the request body follows the request as
+// {"type":"chunk","data":"...","encoding":"base64"} frames terminated by
+// {"type":"end"}. JSON cannot carry raw bytes, so the data is base64 encoded.
+function tusk_read_body($req)
+{
+    if (empty($req['stream'])) {
+        return $req['body'] ?? '';
+    }
+
+    $body = '';
+    while (($line = fgets(STDIN)) !== false) {
+        $frame = json_decode($line, true);
+        if (($frame['type'] ?? '') !== 'chunk') {
+            break; // End frame
+        }
+        $body .= ($frame['encoding'] ?? '') === 'base64'
+            ? base64_decode($frame['data'])
+            : $frame['data'];
+    }
+    return $body;
+}
+
+// When the client goes away mid-request the engine sends {"type":"cancel"}.
+// Long-running handlers may poll this and answer {"type":"cancelled"}
+// instead of a response; workers that keep going past "cancel_grace" are
+// killed and replaced.
+function tusk_cancelled()
+{
+    $read = [STDIN];
+    $write = $except = null;
+    if (!stream_select($read, $write, $except, 0)) {
+        return false;
+    }
+    $line = fgets(STDIN);
+    return $line !== false && (json_decode($line, true)['type'] ?? '') === 'cancel';
+}
+
+// Boot the application here, then tell the engine this worker is ready
+// (only expected when "worker_handshake" is enabled)
+if (getenv('TUSK_HANDSHAKE')) {
+    tusk_send(['type' => 'ready', 'protocol_version' => 1, 'capabilities' => ['streaming', 'ws', 'cancel']]);
+}
+
 while (true) {
     // 1. Read Line (Blocking)
     $line = fgets(STDIN);
@@ -20,13 +68,62 @@ while (true) {
         continue;
     }
 
+    // Health check (see "health_check_interval")
+    if (($req['type'] ?? '') === 'ping') {
+        tusk_send(['type' => 'pong']);
+        continue;
+    }
+
+    // A cancellation that arrived after the response was sent
+    if (($req['type'] ?? '') === 'cancel') {
+        continue;
+    }
+
+    // WebSocket events (see "websocket_routes"): reply with "ws" actions
+    if (isset($req['type']) && strpos($req['type'], 'ws.') === 0) {
+        $actions = [];
+        if ($req['type'] === 'ws.open') {
+            $actions[] = ['action' => 'join', 'channel' => 'chat'];
+        } elseif ($req['type'] === 'ws.message') {
+            $action = ['action' => 'broadcast', 'channel' => 'chat', 'data' => $req['data']];
+            if (!empty($req['binary'])) {
+                // Binary messages arrive base64 encoded and are relayed as such
+                $action['binary'] = true;
+                $action['encoding'] = $req['encoding'];
+            }
+            $actions[] = $action;
+        }
+        if (!empty($req['stream'])) {
+            tusk_read_body($req); // Events have an empty body
+        }
+        tusk_send(['status' => 200, 'ws' => $actions]);
+        continue;
+    }
+
     // 3. Process Request (Placeholder for framework boot)
     // In a real app, this would be: $response = $kernel->handle($request);
 
     $method = $req['method'] ?? 'GET';
     $url = $req['url'] ?? '/';
+    $protocol = $req['protocol'] ?? 'HTTP/1.1'; // SERVER_PROTOCOL
     $headers = $req['headers'] ?? [];
-    $body = $req['body'] ?? '';
+    $body = tusk_read_body($req);
+
+    // Streamed response example: send headers first, then body chunks.
+    // Each chunk is flushed to the client as soon as it is written.
+    if (!empty($req['stream']) && $url === '/stream') {
+        tusk_send([
+            'type' => 'headers',
+            'status' => 200,
+            'headers' => ['Content-Type' => 'text/event-stream'],
+        ]);
+        for ($i = 1; $i <= 5; $i++) {
+            tusk_send(['type' => 'chunk', 'data' => "data: tick $i\n\n"]);
+            sleep(1);
+        }
+        tusk_send(['type' => 'end']);
+        continue;
+    }
 
     // Simple Echo Logic for testing
     $responseBody = json_encode([
@@ -34,6 +131,7 @@ while (true) {
         'received' => [
             'method' => $method,
             'url' => $url,
+            'protocol' => $protocol,
             'headers' => $headers,
             'body_size' => strlen($body),
         ],
@@ -50,5 +148,5 @@ while (true) {
     ];
 
     // 4. Send Response (Unbuffered)
-    fwrite(STDOUT, json_encode($response) . "\n");
+    tusk_send($response);
 }
@@ -0,0 +1,128 @@
+<?php
+
+// Tusk Native Engine - Worker Script (MessagePack)
+// Same as worker.php, but speaks the binary protocol enabled with
+// "protocol": "msgpack" in tusk.json.
+// Protocol: 4-byte big-endian length prefix followed by a MessagePack map.
+// Requires the msgpack extension (pecl install msgpack).
+
+stream_set_write_buffer(STDOUT, 0);
+
+function read_frame()
+{
+    $prefix = read_exact(4);
+    if ($prefix === null) {
+        return null;
+    }
+    $length = unpack('N', $prefix)[1];
+    $payload = $length > 0 ? read_exact($length) : '';
+    if ($payload === null) {
+        return null;
+    }
+    return msgpack_unpack($payload);
+}
+
+function read_exact($length)
+{
+    $data = '';
+    while (strlen($data) < $length) {
+        $chunk = fread(STDIN, $length - strlen($data));
+        if ($chunk === false || $chunk === '') {
+            return null; // End of pipe
+        }
+        $data .= $chunk;
+    }
+    return $data;
+}
+
+function write_frame($message)
+{
+    $payload = msgpack_pack($message);
+    fwrite(STDOUT, pack('N', strlen($payload)) . $payload);
+}
+
+// With "streaming": true the body follows the request as chunk frames
+function read_body($req)
+{
+    if (empty($req['stream'])) {
+        return $req['body'] ?? '';
+    }
+
+    $body = '';
+    while (($frame = read_frame()) !== null && ($frame['type'] ?? '') === 'chunk') {
+        $body .= $frame['data'];
+    }
+    return $body;
+}
+
+// When the client goes away mid-request the engine sends a cancel frame
+// (see worker.php)
+function tusk_cancelled()
+{
+    $read = [STDIN];
+    $write = $except = null;
+    if (!stream_select($read, $write, $except, 0)) {
+        return false;
+    }
+    $frame = read_frame();
+    return is_array($frame) && ($frame['type'] ?? '') === 'cancel';
+}
+
+// Boot the application here, then tell the engine this worker is ready
+// (only expected when "worker_handshake" is enabled)
+if (getenv('TUSK_HANDSHAKE')) {
+    write_frame(['type' => 'ready', 'protocol_version' => 1, 'capabilities' => ['streaming', 'cancel']]);
+}
+
+while (true) {
+    // 1. Read Frame (Blocking)
+    $req = read_frame();
+    if ($req === null) {
+        break; // End of pipe
+    }
+    if (!is_array($req)) {
+        continue;
+    }
+
+    // Health check (see "health_check_interval")
+    if (($req['type'] ?? '') === 'ping') {
+        write_frame(['type' => 'pong']);
+        continue;
+    }
+
+    // A cancellation that arrived after the response was sent
+    if (($req['type'] ?? '') === 'cancel') {
+        continue;
+    }
+
+    // 2. Process Request (Placeholder for framework boot)
+    // In a real app, this would be: $response = $kernel->handle($request);
+
+    $method = $req['method'] ?? 'GET';
+    $url = $req['url'] ?? '/';
+    $protocol = $req['protocol'] ?? 'HTTP/1.1'; // SERVER_PROTOCOL
+    $headers = $req['headers'] ?? [];
+    $body = read_body($req); // Raw bytes, no decoding needed
+
+    $responseBody = json_encode([
+        'message' => 'Hello from Tusk Native Engine!',
+        'received' => [
+            'method' => $method,
+            'url' => $url,
+            'protocol' => $protocol,
+            'headers' => $headers,
+            'body_size' => strlen($body),
+        ],
+        'timestamp' => time(),
+    ]);
+
+    // 3. Send Response
+    write_frame([
+        'status' => 200,
+        'headers' => [
+            'Content-Type' => 'application/json',
+            'X-Tusk-Worker' => getmypid(),
+        ],
+        'body' => $responseBody,
+    ]);
+}